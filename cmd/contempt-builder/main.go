@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/csmith/contempt/pkg/build"
+	"github.com/csmith/envflag/v2"
+)
+
+var (
+	builderName = flag.String("builder", "buildah", fmt.Sprintf("The tool to use to build and push images (one of: %s)", strings.Join(build.Names(), ", ")))
+	registry    = flag.String("registry", "reg.c5h.io", "Registry to use for pushes")
+	image       = flag.String("image", "", "The name of the image to build, instead of <registry>/<project dir name>")
+	push        = flag.Bool("push", false, "Whether to push the image after building it")
+	pushRetries = flag.Int("push-retries", 2, "How many times to retry pushing an image if it fails")
+)
+
+func main() {
+	envflag.Parse()
+
+	if flag.NArg() != 1 {
+		_, _ = fmt.Fprintf(os.Stderr, "Required arguments missing: <project dir>\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	builder, err := build.New(*builderName)
+	if err != nil {
+		log.Fatalf("Failed to create builder: %v", err)
+	}
+
+	if err := builder.Version(); err != nil {
+		log.Fatalf("Contempt is configured to build with %s, but it doesn't seem to be working: %v", *builderName, err)
+	}
+
+	projectDir, err := filepath.Abs(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to resolve project directory: %v", err)
+	}
+
+	imageName := *image
+	if imageName == "" {
+		imageName = fmt.Sprintf("%s/%s", *registry, filepath.Base(projectDir))
+	}
+
+	if err := builder.Build(projectDir, imageName, build.Options{}); err != nil {
+		log.Fatalf("Failed to build %s: %v", imageName, err)
+	}
+
+	if *push {
+		digest, err := build.PushWithRetries(builder, imageName, *pushRetries)
+		if err != nil {
+			log.Fatalf("Failed to push %s: %v", imageName, err)
+		}
+
+		fmt.Printf("%s@%s\n", imageName, digest)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/csmith/contempt"
+	"github.com/csmith/contempt/pkg/materials"
+	"github.com/csmith/contempt/pkg/template"
+	"github.com/csmith/envflag/v2"
+)
+
+var (
+	templateExplicit bool
+	outputExplicit   bool
+	templateName     = flag.String("template", "Dockerfile.gotpl", "The name of the template files")
+	outputName       = flag.String("output", "Dockerfile", "The name of the output files")
+	project          = flag.String("project", "", "The name of the project to generate")
+	sourceLink       = flag.String("source-link", "https://github.com/example/repo/blob/master/", "Link to a browsable version of the source repo")
+	registry         = flag.String("registry", "reg.c5h.io", "Registry to use for pushes and pulls")
+	alpineMirror     = flag.String("alpine-mirror", "https://dl-cdn.alpinelinux.org/alpine/", "Base URL of the Alpine mirror to use to query version and package info")
+	includesDir      = flag.String("includes", "_includes", "Folder of template files to include")
+	callTimeout      = flag.Duration("call-timeout", 2*time.Minute, "Maximum time each template function may take to look up a version (0 for no limit)")
+	renderTimeout    = flag.Duration("render-timeout", 10*time.Minute, "Maximum time rendering each template may take in total (0 for no limit)")
+	offline          = flag.Bool("offline", false, "Whether to answer all template function calls from the lockfile instead of looking them up")
+	lockfilePath     = flag.String("lockfile", "contempt.lock", "Path of the lockfile to read in offline mode")
+	summaryPath      = flag.String("summary", "", "Path to write a JSON summary of the generated project to, instead of stdout")
+)
+
+type summary struct {
+	Project   string             `json:"project"`
+	Template  string             `json:"template"`
+	Output    string             `json:"output"`
+	Materials materials.BOM      `json:"materials"`
+	Changes   []materials.Change `json:"changes"`
+}
+
+func main() {
+	envflag.Parse()
+
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "template" {
+			templateExplicit = true
+		}
+		if f.Name == "output" {
+			outputExplicit = true
+		}
+	})
+
+	if flag.NArg() != 2 {
+		_, _ = fmt.Fprintf(os.Stderr, "Required arguments missing: <input dir> <output dir>\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *project == "" {
+		_, _ = fmt.Fprintf(os.Stderr, "Missing required flag: project\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	engineOptions := []template.Option{
+		template.WithCallTimeout(*callTimeout),
+		template.WithExecutionTimeout(*renderTimeout),
+	}
+
+	if *offline {
+		lockfile, err := template.ReadLockfile(*lockfilePath)
+		if err != nil {
+			log.Fatalf("Failed to read lockfile: %v", err)
+		}
+		engineOptions = append(engineOptions, template.WithOfflineLockfile(lockfile))
+	}
+
+	contempt.InitTemplates(*registry, *alpineMirror, os.DirFS(*includesDir), engineOptions...)
+
+	projectDir, err := filepath.Abs(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to resolve project directory: %v", err)
+	}
+
+	templateNames := []string{*templateName}
+	if !templateExplicit && !outputExplicit {
+		templateNames = []string{"Dockerfile.gotpl", "Containerfile.gotpl"}
+	}
+
+	_, projectTemplates, err := contempt.FindProjects(projectDir, templateNames...)
+	if err != nil {
+		log.Fatalf("Failed to find projects: %v", err)
+	}
+
+	templateForProject, ok := projectTemplates[*project]
+	if !ok {
+		log.Fatalf("Project %s not found in %s", *project, projectDir)
+	}
+
+	outputForProject := contempt.OutputName(templateForProject, *outputName)
+	inPath := filepath.Join(*project, templateForProject)
+	outPath := filepath.Join(flag.Arg(1), *project, outputForProject)
+
+	oldMaterials := materials.Read(outPath)
+	content, newMaterials, err := contempt.Render(context.Background(), *sourceLink, flag.Arg(0), inPath)
+	if err != nil {
+		log.Fatalf("Failed to render template for project %s: %v", *project, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPath), os.FileMode(0755)); err != nil {
+		log.Fatalf("Failed to create output directory for project %s: %v", *project, err)
+	}
+
+	if err := os.WriteFile(outPath, content, os.FileMode(0600)); err != nil {
+		log.Fatalf("Failed to write output for project %s to %s: %v", *project, outPath, err)
+	}
+
+	if err := writeSummary(summary{
+		Project:   *project,
+		Template:  inPath,
+		Output:    outPath,
+		Materials: newMaterials,
+		Changes:   materials.Diff(oldMaterials, newMaterials),
+	}); err != nil {
+		log.Fatalf("Failed to write summary: %v", err)
+	}
+}
+
+func writeSummary(s summary) error {
+	if s.Changes == nil {
+		s.Changes = []materials.Change{}
+	}
+
+	out := os.Stdout
+	if *summaryPath != "" {
+		f, err := os.Create(*summaryPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s)
+}
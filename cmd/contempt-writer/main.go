@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/csmith/contempt/pkg/commit"
+	"github.com/csmith/contempt/pkg/materials"
+	"github.com/csmith/envflag/v2"
+)
+
+var (
+	project     = flag.String("project", "", "The name of the project being written, instead of the name of the target file's directory")
+	message     = flag.String("message", commit.DefaultMessage, "Template to use for commit messages")
+	authorName  = flag.String("author-name", "", "Name to use as the author and committer of commits, instead of git's configured user")
+	authorEmail = flag.String("author-email", "", "Email address to use as the author and committer of commits, instead of git's configured user")
+	trailers    stringList
+)
+
+func init() {
+	flag.Var(&trailers, "trailer", "Trailer to add to the commit message (e.g. \"Signed-off-by: ...\"); may be repeated")
+}
+
+func main() {
+	envflag.Parse()
+
+	if flag.NArg() != 2 {
+		_, _ = fmt.Fprintf(os.Stderr, "Required arguments missing: <rendered file> <target file>\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	renderedPath := flag.Arg(0)
+	targetPath := flag.Arg(1)
+
+	projectName := *project
+	if projectName == "" {
+		abs, err := filepath.Abs(targetPath)
+		if err != nil {
+			log.Fatalf("Failed to resolve target path: %v", err)
+		}
+		projectName = filepath.Base(filepath.Dir(abs))
+	}
+
+	committer, err := commit.New(
+		filepath.Dir(targetPath),
+		*message,
+		commit.WithAuthor(*authorName, *authorEmail),
+		commit.WithTrailers(trailers...),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create committer: %v", err)
+	}
+
+	if err := committer.Version(); err != nil {
+		log.Fatalf("Contempt is configured to commit, but git doesn't seem to be working: %v", err)
+	}
+
+	content, err := os.ReadFile(renderedPath)
+	if err != nil {
+		log.Fatalf("Failed to read rendered file: %v", err)
+	}
+
+	existing, err := os.ReadFile(targetPath)
+	if err == nil && bytes.Equal(content, existing) {
+		log.Printf("Target file %s is already up to date", targetPath)
+		return
+	}
+
+	oldMaterials := materials.Read(targetPath)
+	newMaterials := materials.Read(renderedPath)
+
+	if err := os.WriteFile(targetPath, content, os.FileMode(0600)); err != nil {
+		log.Fatalf("Failed to write target file %s: %v", targetPath, err)
+	}
+
+	if err := committer.Commit(projectName, filepath.Base(targetPath), materials.Diff(oldMaterials, newMaterials)); err != nil {
+		log.Fatalf("Failed to commit %s: %v", targetPath, err)
+	}
+}
+
+// stringList is a flag.Value that accumulates each value it is given.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
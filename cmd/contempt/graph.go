@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/csmith/contempt"
+)
+
+// graphFormats are the formats the graph command can write the dependency graph in.
+var graphFormats = map[string]func(io.Writer, []*contempt.Project) error{
+	"dot":     writeGraphDOT,
+	"json":    writeGraphJSON,
+	"mermaid": writeGraphMermaid,
+}
+
+// writeGraphDOT writes the dependency graph in Graphviz's DOT language, with an edge from each project to each of
+// its dependencies.
+func writeGraphDOT(w io.Writer, projects []*contempt.Project) error {
+	var b strings.Builder
+	b.WriteString("digraph contempt {\n")
+	b.WriteString("  rankdir=BT;\n")
+	b.WriteString("  node [shape=box];\n")
+	for _, p := range projects {
+		_, _ = fmt.Fprintf(&b, "  %q [tooltip=%q];\n", p.Name, p.Template)
+	}
+	for _, p := range projects {
+		for _, dep := range p.Dependencies {
+			_, _ = fmt.Fprintf(&b, "  %q -> %q;\n", p.Name, dep)
+		}
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeGraphMermaid writes the dependency graph as a Mermaid flowchart, with an edge from each project to each of
+// its dependencies.
+func writeGraphMermaid(w io.Writer, projects []*contempt.Project) error {
+	ids := make(map[string]string)
+	for i, p := range projects {
+		ids[p.Name] = fmt.Sprintf("p%d", i)
+	}
+
+	var b strings.Builder
+	b.WriteString("flowchart BT\n")
+	for _, p := range projects {
+		_, _ = fmt.Fprintf(&b, "  %s[\"%s\"]\n", ids[p.Name], strings.ReplaceAll(p.Name, `"`, "#quot;"))
+	}
+	for _, p := range projects {
+		for _, dep := range p.Dependencies {
+			_, _ = fmt.Fprintf(&b, "  %s --> %s\n", ids[p.Name], ids[dep])
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeGraphJSON writes the dependency graph as a JSON object containing a list of projects.
+func writeGraphJSON(w io.Writer, projects []*contempt.Project) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Projects []*contempt.Project `json:"projects"`
+	}{projects})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/csmith/contempt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func graphProjects() []*contempt.Project {
+	return []*contempt.Project{
+		{Name: "base", Template: "base/Dockerfile.gotpl", Dependents: []string{"golang-1.23", `say "hi"`}},
+		{Name: "golang-1.23", Template: "golang-1.23/Dockerfile.gotpl", Dependencies: []string{"base"}, Dependents: []string{`say "hi"`}, Level: 1},
+		{Name: `say "hi"`, Template: `say "hi"/Dockerfile.gotpl`, Dependencies: []string{"base", "golang-1.23"}, Level: 2},
+	}
+}
+
+func TestWriteGraph(t *testing.T) {
+	tests := []struct {
+		name     string
+		write    func(w io.Writer, projects []*contempt.Project) error
+		projects []*contempt.Project
+		want     string
+	}{
+		{
+			name:     "dot",
+			write:    writeGraphDOT,
+			projects: graphProjects(),
+			want: `digraph contempt {
+  rankdir=BT;
+  node [shape=box];
+  "base" [tooltip="base/Dockerfile.gotpl"];
+  "golang-1.23" [tooltip="golang-1.23/Dockerfile.gotpl"];
+  "say \"hi\"" [tooltip="say \"hi\"/Dockerfile.gotpl"];
+  "golang-1.23" -> "base";
+  "say \"hi\"" -> "base";
+  "say \"hi\"" -> "golang-1.23";
+}
+`,
+		},
+		{
+			name:     "dot without projects",
+			write:    writeGraphDOT,
+			projects: nil,
+			want:     "digraph contempt {\n  rankdir=BT;\n  node [shape=box];\n}\n",
+		},
+		{
+			name:     "mermaid",
+			write:    writeGraphMermaid,
+			projects: graphProjects(),
+			want: `flowchart BT
+  p0["base"]
+  p1["golang-1.23"]
+  p2["say #quot;hi#quot;"]
+  p1 --> p0
+  p2 --> p0
+  p2 --> p1
+`,
+		},
+		{
+			name:     "mermaid without projects",
+			write:    writeGraphMermaid,
+			projects: nil,
+			want:     "flowchart BT\n",
+		},
+		{
+			name:     "json",
+			write:    writeGraphJSON,
+			projects: graphProjects(),
+			want: `{
+  "projects": [
+    {
+      "name": "base",
+      "template": "base/Dockerfile.gotpl",
+      "dependencies": null,
+      "dependents": [
+        "golang-1.23",
+        "say \"hi\""
+      ],
+      "config": {},
+      "level": 0
+    },
+    {
+      "name": "golang-1.23",
+      "template": "golang-1.23/Dockerfile.gotpl",
+      "dependencies": [
+        "base"
+      ],
+      "dependents": [
+        "say \"hi\""
+      ],
+      "config": {},
+      "level": 1
+    },
+    {
+      "name": "say \"hi\"",
+      "template": "say \"hi\"/Dockerfile.gotpl",
+      "dependencies": [
+        "base",
+        "golang-1.23"
+      ],
+      "dependents": null,
+      "config": {},
+      "level": 2
+    }
+  ]
+}
+`,
+		},
+		{
+			name:     "json without projects",
+			write:    writeGraphJSON,
+			projects: nil,
+			want:     "{\n  \"projects\": null\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &strings.Builder{}
+			require.NoError(t, tt.write(b, tt.projects))
+			assert.Equal(t, tt.want, b.String())
+		})
+	}
+}
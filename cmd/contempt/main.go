@@ -1,19 +1,23 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"github.com/csmith/contempt/pkg/materials"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/csmith/contempt"
+	"github.com/csmith/contempt/pkg/build"
+	"github.com/csmith/contempt/pkg/commit"
+	"github.com/csmith/contempt/pkg/materials"
+	"github.com/csmith/contempt/pkg/template"
+	"github.com/csmith/contempt/pkg/template/sources"
 	"github.com/csmith/envflag/v2"
-	"golang.org/x/exp/slices"
 )
 
 var (
@@ -23,8 +27,8 @@ var (
 	outputName       = flag.String("output", "Dockerfile", "The name of the output files")
 	filter           = flag.String("project", "", "A comma-separated list of projects to generate, instead of all detected ones")
 	sourceLink       = flag.String("source-link", "https://github.com/example/repo/blob/master/", "Link to a browsable version of the source repo")
-	commit           = flag.Bool("commit", false, "Whether to automatically git commit each changed file")
-	build            = flag.Bool("build", false, "Whether to automatically build on successful commit")
+	doCommit         = flag.Bool("commit", false, "Whether to automatically git commit each changed file")
+	doBuild          = flag.Bool("build", false, "Whether to automatically build on successful commit")
 	forceBuild       = flag.Bool("force-build", false, "Whether to build projects regardless of changes")
 	push             = flag.Bool("push", false, "Whether to automatically push on successful commit")
 	pushRetries      = flag.Int("push-retries", 2, "How many times to retry pushing an image if it fails")
@@ -32,9 +36,36 @@ var (
 	registry         = flag.String("registry", "reg.c5h.io", "Registry to use for pushes and pulls")
 	alpineMirror     = flag.String("alpine-mirror", "https://dl-cdn.alpinelinux.org/alpine/", "Base URL of the Alpine mirror to use to query version and package info")
 	includesDir      = flag.String("includes", "_includes", "Folder of template files to include")
+	callTimeout      = flag.Duration("call-timeout", 2*time.Minute, "Maximum time each template function may take to look up a version (0 for no limit)")
+	renderTimeout    = flag.Duration("render-timeout", 10*time.Minute, "Maximum time rendering each template may take in total (0 for no limit)")
+	reportPath       = flag.String("report", "", "Path to write a JSON report of the run to")
+	parallel         = flag.Int("parallel", 1, "How many projects within the same level of the dependency tree to process concurrently")
+	offline          = flag.Bool("offline", false, "Whether to answer all template function calls from the lockfile instead of looking them up")
+	lockfilePath     = flag.String("lockfile", "contempt.lock", "Path of the lockfile written by the lock command, and read in offline mode")
+	check            = flag.Bool("check", false, "Whether to only report projects with outdated materials, without writing, committing or building anything")
+	builderName      = flag.String("builder", "buildah", fmt.Sprintf("The tool to use to build and push images (one of: %s)", strings.Join(build.Names(), ", ")))
+	cascade          = flag.Bool("cascade", false, "Whether to also process the dependents of each pushed project, even if they weren't selected with -project")
+	graphFormat      = flag.String("graph-format", "dot", "The format the graph command writes the dependency graph in (one of: dot, json, mermaid)")
+
+	builder     build.Builder
+	committer   *commit.Committer
+	commitMutex sync.Mutex
+	lockfile    *template.Lockfile
 )
 
 func main() {
+	// "contempt lock" renders every template in memory, and records the results of all template function calls.
+	locking := len(os.Args) > 1 && os.Args[1] == "lock"
+	if locking {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
+	// "contempt graph" writes the dependency graph between projects to stdout.
+	graphing := len(os.Args) > 1 && os.Args[1] == "graph"
+	if graphing {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	envflag.Parse()
 
 	flag.Visit(func(f *flag.Flag) {
@@ -46,13 +77,35 @@ func main() {
 		}
 	})
 
-	if flag.NArg() != 2 {
+	if graphing && flag.NArg() != 1 {
+		_, _ = fmt.Fprintf(os.Stderr, "Required arguments missing: graph <input dir>\n")
+		flag.Usage()
+		os.Exit(2)
+	} else if !graphing && flag.NArg() != 2 {
 		_, _ = fmt.Fprintf(os.Stderr, "Required arguments missing: <input dir> <output dir>\n")
 		flag.Usage()
 		os.Exit(2)
 	}
 
-	contempt.InitTemplates(*registry, *alpineMirror, os.DirFS(*includesDir))
+	engineOptions := []template.Option{
+		template.WithCallTimeout(*callTimeout),
+		template.WithExecutionTimeout(*renderTimeout),
+	}
+
+	if locking && *offline {
+		log.Fatalf("The lock command can't be used in offline mode")
+	} else if locking {
+		lockfile = template.NewLockfile()
+		engineOptions = append(engineOptions, template.WithLockfile(lockfile))
+	} else if *offline {
+		lf, err := template.ReadLockfile(*lockfilePath)
+		if err != nil {
+			log.Fatalf("Failed to read lockfile: %v", err)
+		}
+		engineOptions = append(engineOptions, template.WithOfflineLockfile(lf))
+	}
+
+	contempt.InitTemplates(*registry, *alpineMirror, os.DirFS(*includesDir), engineOptions...)
 
 	projectDir, err := filepath.Abs(flag.Arg(0))
 	if err != nil {
@@ -64,162 +117,325 @@ func main() {
 		templateNames = []string{"Dockerfile.gotpl", "Containerfile.gotpl"}
 	}
 
-	projects, projectTemplates, err := contempt.FindProjects(projectDir, templateNames...)
+	if graphing {
+		writeGraph(projectDir, templateNames)
+		return
+	}
+
+	projects, err := contempt.FindProjectGraph(projectDir, templateNames...)
 	if err != nil {
 		log.Fatalf("Failed to find projects: %v", err)
 	}
 
-	checkExternalDependencies()
+	if *cascade && !(*doCommit && *doBuild && *push) {
+		log.Fatalf("The -cascade flag requires -commit, -build and -push")
+	}
 
-	filtered := strings.Split(*filter, ",")
+	builder, err = build.New(*builderName)
+	if err != nil {
+		log.Fatalf("Failed to create builder: %v", err)
+	}
 
-	for i := range projects {
-		if *filter == "" || slices.Contains(filtered, projects[i]) {
-			if *workflowCommands {
-				fmt.Printf("::group::%s\n", projects[i])
-			}
-			log.Printf("Checking project %s", projects[i])
-			templateForProject := projectTemplates[projects[i]]
-			outputForProject := *outputName
-			if templateForProject == "Containerfile.gotpl" {
-				outputForProject = "Containerfile"
+	committer, err = commit.New(flag.Arg(1), commit.DefaultMessage)
+	if err != nil {
+		log.Fatalf("Failed to create committer: %v", err)
+	}
+
+	if !*check && !locking {
+		checkExternalDependencies()
+	}
+
+	selection := newProjectSelection(*filter, *cascade, projects)
+	results := &report{Started: time.Now()}
+
+	var levels [][]*contempt.Project
+	for _, p := range projects {
+		for len(levels) <= p.Level {
+			levels = append(levels, nil)
+		}
+		levels[p.Level] = append(levels[p.Level], p)
+	}
+
+	for l := range levels {
+		var pending []*projectResult
+		for _, p := range levels[l] {
+			if selection.includes(p.Name) {
+				templateForProject := filepath.Base(p.Template)
+				outputForProject := contempt.OutputName(templateForProject, *outputName)
+
+				result := results.add(
+					p.Name,
+					filepath.Join(p.Name, templateForProject),
+					filepath.Join(p.Name, outputForProject),
+				)
+				result.config = p.Config
+				pending = append(pending, result)
 			}
+		}
 
-			outPath := filepath.Join(flag.Arg(1), projects[i], outputForProject)
-			changes, err := contempt.Generate(*sourceLink, flag.Arg(0), filepath.Join(projects[i], templateForProject), outPath)
-			if err != nil {
-				log.Fatalf("Failed to generate project %s: %v", projects[i], err)
+		if failed := runLevel(pending); failed != nil {
+			writeReport(results)
+			log.Fatalf("Failed to process project %s: %s", failed.Project, failed.Error)
+		}
+
+		for _, result := range pending {
+			if result.Pushed {
+				selection.pushed(result.Project)
 			}
+		}
+	}
 
-			if *commit {
-				if err := doCommit(projects[i], outputForProject, changes); err != nil {
-					log.Printf("Failed to commit %s: %v", projects[i], err)
-					continue
-				}
+	writeReport(results)
+
+	if locking {
+		if err := lockfile.Write(*lockfilePath); err != nil {
+			log.Fatalf("Failed to write lockfile: %v", err)
+		}
+		log.Printf("Recorded %d calls in lockfile %s", lockfile.Len(), *lockfilePath)
+	}
+
+	outdated := 0
+	for i := range results.Projects {
+		if results.Projects[i].Outdated {
+			outdated++
+		}
+	}
+
+	if outdated > 0 {
+		log.Printf("%d project(s) are out of date", outdated)
+		os.Exit(1)
+	}
+}
+
+// writeGraph writes the dependency graph of all projects to stdout, in the format given by the -graph-format flag.
+func writeGraph(projectDir string, templateNames []string) {
+	write, ok := graphFormats[*graphFormat]
+	if !ok {
+		log.Fatalf("Unknown graph format: %s", *graphFormat)
+	}
+
+	projects, err := contempt.FindProjectGraph(projectDir, templateNames...)
+	if err != nil {
+		log.Fatalf("Failed to find projects: %v", err)
+	}
+
+	if err := write(os.Stdout, projects); err != nil {
+		log.Fatalf("Failed to write graph: %v", err)
+	}
+}
+
+// runLevel processes all the given projects, which must not depend on one another. Up to -parallel projects are
+// processed at once. If processing any project fails, no further projects are started, and the first failed project
+// is returned once all in-progress projects have finished.
+func runLevel(pending []*projectResult) *projectResult {
+	if *parallel <= 1 {
+		for i := range pending {
+			if !runProject(pending[i]) {
+				return pending[i]
 			}
+		}
+		return nil
+	}
 
-			if (*commit && *build) || *forceBuild {
-				imageName := fmt.Sprintf("%s/%s", *registry, projects[i])
-				if err := runBuildahCommand(
-					"bud",
-					"--timestamp",
-					"0",
-					"--layers",
-					"--tag",
-					imageName,
-					filepath.Join(flag.Arg(1), projects[i]),
-				); err != nil {
-					log.Fatalf("Failed to build %s: %v", projects[i], err)
-				}
+	var (
+		wg     sync.WaitGroup
+		mutex  sync.Mutex
+		failed *projectResult
+		jobs   = make(chan *projectResult)
+	)
 
-				if *push {
-					success := false
-					for r := 0; r <= *pushRetries && !success; r++ {
-						if err := runBuildahCommand("push", imageName); err == nil {
-							success = true
-						} else {
-							log.Printf("Failed to push %s [attempt %d/%d]: %v", projects[i], r+1, *pushRetries+1, err)
-						}
-					}
-					if !success {
-						log.Fatalf("Failed to push %s after %d attempts", projects[i], *pushRetries+1)
+	for w := 0; w < *parallel && w < len(pending); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for result := range jobs {
+				if !runProject(result) {
+					mutex.Lock()
+					if failed == nil {
+						failed = result
 					}
+					mutex.Unlock()
 				}
 			}
-			if *workflowCommands {
-				fmt.Printf("::endgroup::\n")
-			}
+		}()
+	}
+
+	for i := range pending {
+		mutex.Lock()
+		stop := failed != nil
+		mutex.Unlock()
+		if stop {
+			break
 		}
+		jobs <- pending[i]
 	}
+
+	close(jobs)
+	wg.Wait()
+	return failed
 }
 
-func doCommit(project, outputForProject string, changes []materials.Change) error {
-	if err := runGitCommand(
-		"-C",
-		flag.Arg(1),
-		"add",
-		filepath.Join(project, outputForProject),
-	); err != nil {
-		return err
+// runProject checks or processes a single project, returning false if it failed.
+func runProject(result *projectResult) bool {
+	// Workflow command groups can't be interleaved, so they're only used when processing projects one at a time.
+	groups := *workflowCommands && *parallel <= 1
+	if groups {
+		fmt.Printf("::group::%s\n", result.Project)
+		defer fmt.Printf("::endgroup::\n")
 	}
+	log.Printf("Checking project %s", result.Project)
 
-	if err := runGitCommand(
-		"-C",
-		flag.Arg(1),
-		"commit",
-		"--no-gpg-sign",
-		"-m",
-		fmt.Sprintf("[%s] %s", project, formatChanges(changes)),
-		filepath.Join(project, outputForProject),
-	); err != nil {
-		return err
+	var err error
+	if lockfile != nil {
+		err = lockProject(result)
+	} else if *check {
+		err = checkProject(result)
+	} else {
+		err = processProject(result)
 	}
-	return nil
-}
 
-func runGitCommand(args ...string) error {
-	return runCommand(exec.Command(
-		"git",
-		args...,
-	))
+	if err != nil {
+		result.Error = err.Error()
+		return false
+	}
+	return true
 }
 
-func runBuildahCommand(args ...string) error {
-	return runCommand(exec.Command(
-		"/usr/bin/buildah",
-		args...,
-	))
+// lockProject renders the project in memory, so that the template function calls it makes are recorded in the
+// lockfile.
+func lockProject(result *projectResult) error {
+	return result.time("generate", func() error {
+		_, _, err := contempt.Render(context.Background(), *sourceLink, flag.Arg(0), result.Template)
+		return err
+	})
 }
 
-func runCommand(cmd *exec.Cmd) error {
-	log.Printf("Running \"%s\"", strings.Join(cmd.Args, "\" \""))
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
+// checkProject renders the project in memory and reports whether it is outdated.
+func checkProject(result *projectResult) error {
+	return result.time("generate", func() error {
+		changes, changed, err := contempt.Check(context.Background(), *sourceLink, flag.Arg(0), result.Template, filepath.Join(flag.Arg(1), result.Output))
+		if err != nil {
+			return err
+		}
 
-func checkExternalDependencies() {
-	if *build || *forceBuild {
-		if err := runBuildahCommand("--version"); err != nil {
-			log.Fatalf("Contempt is configured to build, but buildah doesn't seem to be working: %v", err)
+		if changed {
+			result.Outdated = true
+			result.Changes = append(result.Changes, changes...)
+			printChanges(result.Project, changes)
 		}
+		return nil
+	})
+}
+
+// processProject generates the project, and then commits, builds and pushes it as configured. Failures to commit
+// are recorded in the result but otherwise ignored; all other errors are returned.
+func processProject(result *projectResult) error {
+	if err := result.time("generate", func() error {
+		changes, err := contempt.Generate(context.Background(), *sourceLink, flag.Arg(0), result.Template, filepath.Join(flag.Arg(1), result.Output))
+		result.Changes = append(result.Changes, changes...)
+		return err
+	}); err != nil {
+		return fmt.Errorf("unable to generate: %v", err)
 	}
 
-	if *commit {
-		if err := runGitCommand("--version"); err != nil {
-			log.Fatalf("Contempt is configured to commit, but git doesn't seem to be working: %v", err)
+	if *doCommit {
+		// Git can only make one commit at a time, so commits are serialised even when processing in parallel.
+		commitMutex.Lock()
+		err := result.time("commit", func() error {
+			return committer.Commit(result.Project, result.Output, result.Changes)
+		})
+		commitMutex.Unlock()
+
+		if err != nil {
+			log.Printf("Failed to commit %s: %v", result.Project, err)
+			result.Error = err.Error()
+			return nil
 		}
+		result.Committed = true
 	}
-}
 
-func formatChanges(changes []materials.Change) string {
-	if len(changes) == 0 {
-		return "no detected changes"
-	}
+	if (*doCommit && *doBuild) || *forceBuild {
+		images := result.config.ImageNames(*registry, result.Project)
+		result.Image = images[0]
+		dir := filepath.Join(flag.Arg(1), result.Project)
+		if err := result.time("build", func() error {
+			return builder.Build(dir, result.Image, build.Options{
+				Tags:      images[1:],
+				BuildArgs: result.config.BuildArgs,
+				Target:    result.config.Target,
+				Platforms: result.config.Platforms,
+				Context:   result.config.ContextDir(dir),
+			})
+		}); err != nil {
+			return fmt.Errorf("unable to build %s: %v", result.Image, err)
+		}
+		result.Built = true
 
-	builder := strings.Builder{}
+		if *push && result.config.ShouldPush() {
+			if err := result.time("push", func() error {
+				for i := range images {
+					digest, err := build.PushWithRetries(builder, images[i], *pushRetries)
+					if err != nil {
+						return err
+					}
+					if i == 0 {
+						result.Digest = digest
+					}
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			result.Pushed = true
 
-	if len(changes) > 1 {
-		builder.WriteString(fmt.Sprintf("%d changes\n", len(changes)))
+			if result.Digest != "" {
+				// Make sure dependents use the image we just pushed, rather than a cached or not-yet-updated digest.
+				for _, image := range images {
+					if strings.LastIndexByte(image, ':') < strings.LastIndexByte(image, '/') {
+						sources.RecordPushedImage(image, result.Digest)
+					}
+				}
+			}
+		}
+	}
 
-		sort.Slice(changes, func(i, j int) bool {
-			return changes[i].Material < changes[j].Material
-		})
+	return nil
+}
+
+func writeReport(results *report) {
+	if err := results.write(*reportPath); err != nil {
+		log.Printf("Failed to write report to %s: %v", *reportPath, err)
 	}
+}
 
+func printChanges(project string, changes []materials.Change) {
+	fmt.Printf("%s is out of date:\n", project)
+	if len(changes) == 0 {
+		fmt.Printf("  no material changes, but the output differs\n")
+	}
 	for i := range changes {
 		oldVersion := changes[i].Old
+		if oldVersion == "" {
+			oldVersion = "(unknown)"
+		}
 		newVersion := changes[i].New
-		if oldVersion == "" && newVersion == "" {
-			builder.WriteString(fmt.Sprintf("\n%s unknown changes", changes[i].Material))
-		} else if oldVersion == "" {
-			builder.WriteString(fmt.Sprintf("\n%s (unknown)->%.8s", changes[i].Material, newVersion))
-		} else if newVersion == "" {
-			builder.WriteString(fmt.Sprintf("\n%s %.8s->(unknown)", changes[i].Material, oldVersion))
-		} else {
-			builder.WriteString(fmt.Sprintf("\n%s %.12s->%.12s", changes[i].Material, oldVersion, newVersion))
+		if newVersion == "" {
+			newVersion = "(unknown)"
 		}
+		fmt.Printf("  %s %s -> %s\n", changes[i].Material, oldVersion, newVersion)
 	}
+}
 
-	return strings.TrimPrefix(builder.String(), "\n")
+func checkExternalDependencies() {
+	if *doBuild || *forceBuild {
+		if err := builder.Version(); err != nil {
+			log.Fatalf("Contempt is configured to build, but %s doesn't seem to be working: %v", *builderName, err)
+		}
+	}
+
+	if *doCommit {
+		if err := committer.Version(); err != nil {
+			log.Fatalf("Contempt is configured to commit, but git doesn't seem to be working: %v", err)
+		}
+	}
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/csmith/contempt"
+	"github.com/csmith/contempt/pkg/materials"
+)
+
+// report describes the outcome of a run of contempt, for writing to the file given by the -report flag.
+type report struct {
+	Started  time.Time        `json:"started"`
+	Finished time.Time        `json:"finished"`
+	Projects []*projectResult `json:"projects"`
+}
+
+// projectResult describes what happened to a single project during a run.
+type projectResult struct {
+	Project   string             `json:"project"`
+	Template  string             `json:"template"`
+	Output    string             `json:"output"`
+	Changes   []materials.Change `json:"changes"`
+	Outdated  bool               `json:"outdated"`
+	Committed bool               `json:"committed"`
+	Built     bool               `json:"built"`
+	Pushed    bool               `json:"pushed"`
+	Image     string             `json:"image,omitempty"`
+	Digest    string             `json:"digest,omitempty"`
+	Timings   map[string]int64   `json:"timings_ms"`
+	Error     string             `json:"error,omitempty"`
+
+	config contempt.ProjectConfig
+}
+
+func (r *report) add(project, template, output string) *projectResult {
+	res := &projectResult{
+		Project:  project,
+		Template: template,
+		Output:   output,
+		Changes:  []materials.Change{},
+		Timings:  make(map[string]int64),
+	}
+	r.Projects = append(r.Projects, res)
+	return res
+}
+
+// time runs the given function, and records how long it took under the given step name.
+func (p *projectResult) time(step string, f func() error) error {
+	start := time.Now()
+	defer func() {
+		p.Timings[step] = time.Since(start).Milliseconds()
+	}()
+	return f()
+}
+
+// write writes the report as JSON to the given path. If the path is empty, nothing is written.
+func (r *report) write(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	r.Finished = time.Now()
+	bs, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, bs, os.FileMode(0644))
+}
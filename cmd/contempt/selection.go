@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/csmith/contempt"
+	"golang.org/x/exp/slices"
+)
+
+// projectSelection decides which projects are processed in a run: the projects given with the -project flag (or all
+// projects, if it wasn't given), plus in cascade mode any project that depends on a project pushed earlier in the run.
+type projectSelection struct {
+	filter     []string
+	cascade    bool
+	dependents map[string][]string
+	cascaded   map[string]bool
+}
+
+func newProjectSelection(filter string, cascade bool, projects []*contempt.Project) *projectSelection {
+	s := &projectSelection{
+		cascade:    cascade,
+		dependents: make(map[string][]string),
+		cascaded:   make(map[string]bool),
+	}
+	if filter != "" {
+		s.filter = strings.Split(filter, ",")
+	}
+	for _, p := range projects {
+		s.dependents[p.Name] = p.Dependents
+	}
+	return s
+}
+
+// includes determines whether the named project should be processed.
+func (s *projectSelection) includes(name string) bool {
+	return s.filter == nil || slices.Contains(s.filter, name) || s.cascaded[name]
+}
+
+// pushed records that the named project was pushed, so that in cascade mode its dependents are also processed.
+func (s *projectSelection) pushed(name string) {
+	if !s.cascade {
+		return
+	}
+
+	for _, dependent := range s.dependents[name] {
+		if !s.includes(dependent) {
+			log.Printf("Cascading update of %s to dependent project %s", name, dependent)
+		}
+		s.cascaded[dependent] = true
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/csmith/contempt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjectSelection(t *testing.T) {
+	projects := []*contempt.Project{
+		{Name: "base", Dependents: []string{"go", "other"}},
+		{Name: "go", Dependents: []string{"app"}},
+		{Name: "other"},
+		{Name: "app"},
+	}
+
+	included := func(s *projectSelection) []string {
+		var res []string
+		for _, p := range projects {
+			if s.includes(p.Name) {
+				res = append(res, p.Name)
+			}
+		}
+		return res
+	}
+
+	t.Run("no filter", func(t *testing.T) {
+		s := newProjectSelection("", false, projects)
+		assert.Equal(t, []string{"base", "go", "other", "app"}, included(s))
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		s := newProjectSelection("base,app", false, projects)
+		assert.Equal(t, []string{"base", "app"}, included(s))
+
+		// Without cascading, pushes don't change the selection.
+		s.pushed("base")
+		assert.Equal(t, []string{"base", "app"}, included(s))
+	})
+
+	t.Run("cascade", func(t *testing.T) {
+		s := newProjectSelection("base", true, projects)
+		assert.Equal(t, []string{"base"}, included(s))
+
+		s.pushed("base")
+		assert.Equal(t, []string{"base", "go", "other"}, included(s))
+
+		// Dependents are only added by projects that were pushed, level by level.
+		s.pushed("other")
+		assert.Equal(t, []string{"base", "go", "other"}, included(s))
+
+		s.pushed("go")
+		assert.Equal(t, []string{"base", "go", "other", "app"}, included(s))
+	})
+}
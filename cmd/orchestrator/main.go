@@ -2,16 +2,18 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/csmith/contempt"
 	"github.com/csmith/contempt/internal"
 	"github.com/csmith/envflag/v2"
-	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
 	"gopkg.in/osteele/liquid.v1"
 	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 )
 
@@ -20,6 +22,7 @@ var (
 	registry = flags.String("registry", "", "The name of the registry that images are pushed to")
 	template = flags.String("template", "", "Path of the template to read")
 	output   = flags.String("output", "", "Path to output the generated file")
+	includes = flags.String("includes", "_includes", "Folder of template files to include, relative to the input dir")
 )
 
 func main() {
@@ -40,6 +43,8 @@ func main() {
 	})
 
 	s := os.DirFS(flags.Arg(0))
+	// Templates are only ever dry-run, which doesn't call any functions, so the Alpine mirror is never used.
+	contempt.InitTemplates(*registry, "", os.DirFS(filepath.Join(flags.Arg(0), *includes)))
 
 	files, err := internal.FindFiles(s, func(s string) bool {
 		return strings.ToLower(s) == "dockerfile" || strings.ToLower(s) == "containerfile"
@@ -50,16 +55,37 @@ func main() {
 		os.Exit(3)
 	}
 
+	configs := make(map[string]contempt.ProjectConfig)
+	for i := range files {
+		config, err := contempt.LoadProjectConfig(filepath.Join(flags.Arg(0), filepath.FromSlash(path.Dir(files[i]))))
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to load config of %s: %v\n", files[i], err)
+			os.Exit(4)
+		}
+		configs[path.Base(path.Dir(files[i]))] = config
+	}
+	index := contempt.NewImageIndex(*registry, configs)
+
 	var deps []target
 	for i := range files {
 		name := path.Base(path.Dir(files[i]))
-		needed, err := readDependencies(s, *registry, files[i])
+		needed, err := projectDependencies(s, flags.Arg(0), index, files[i])
 		if err != nil {
 			_, _ = fmt.Fprintf(os.Stderr, "Failed to find dependencies of %s: %v\n", files[i], err)
 			os.Exit(4)
 		}
 
-		deps = append(deps, target{name, needed})
+		config := configs[name]
+		deps = append(deps, target{
+			Name:      name,
+			Needed:    needed,
+			Images:    config.ImageNames(*registry, name),
+			BuildArgs: config.BuildArgs,
+			Target:    config.Target,
+			Platforms: config.Platforms,
+			Context:   config.Context,
+			Push:      config.ShouldPush(),
+		})
 	}
 
 	deps, err = orderDependencies(deps)
@@ -91,93 +117,110 @@ func main() {
 }
 
 type target struct {
-	Name   string   `liquid:"name"`
-	Needed []string `liquid:"needed"`
+	Name      string            `liquid:"name"`
+	Needed    []string          `liquid:"needed"`
+	Images    []string          `liquid:"images"`
+	BuildArgs map[string]string `liquid:"build_args"`
+	Target    string            `liquid:"target"`
+	Platforms []string          `liquid:"platforms"`
+	Context   string            `liquid:"context"`
+	Push      bool              `liquid:"push"`
 }
 
-// TODO: At some point this should switch to read the template syntax used by the writer
-func readDependencies(s fs.FS, registry string, p string) ([]string, error) {
+// projectDependencies returns the dependencies of the project that generates the given Dockerfile or Containerfile.
+// If the file was generated from a template, the images it uses are found by dry-running the template in the same
+// way as contempt does. Otherwise, they are read from the file itself.
+func projectDependencies(s fs.FS, dir string, index contempt.ImageIndex, p string) ([]string, error) {
+	var images []string
+	templateName := fmt.Sprintf("%s.gotpl", path.Base(p))
+	if _, err := fs.Stat(s, path.Join(path.Dir(p), templateName)); err == nil {
+		images, err = contempt.TemplateImages(filepath.Join(dir, filepath.FromSlash(path.Dir(p))), templateName)
+		if err != nil {
+			return nil, err
+		}
+	} else if errors.Is(err, fs.ErrNotExist) {
+		images, err = readImages(s, p)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+
+	// Ignore dependencies on yourself
+	return index.Dependencies(path.Base(path.Dir(p)), images), nil
+}
+
+// readImages finds the registry-qualified images used by a rendered Dockerfile or Containerfile in FROM instructions,
+// COPY --from flags, and RUN --mount flags. Unqualified names, such as references to earlier build stages, are
+// ignored.
+func readImages(s fs.FS, p string) ([]string, error) {
 	f, err := s.Open(p)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	// Ignore dependencies on yourself
-	ownName := path.Base(path.Dir(p))
-
-	dependencies := make(map[string]bool)
-	repo := fmt.Sprintf("%s/", strings.ToLower(registry))
+	var res []string
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
 		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
 		parts := strings.Fields(line)
-		if len(parts) >= 2 && parts[0] == "from" && strings.HasPrefix(parts[1], repo) {
-			name := strings.TrimPrefix(parts[1], repo)
-			name, _, _ = strings.Cut(name, "@")
-			name, _, _ = strings.Cut(name, ":")
-			if name != ownName {
-				dependencies[name] = true
-			}
+		if len(parts) < 2 {
+			continue
 		}
-	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-
-	deps := maps.Keys(dependencies)
-	slices.Sort(deps)
-	return deps, nil
-}
-
-// TODO: There's probably a better way to do this (e.g. by traversing the graph). Do that and test.
-func orderDependencies(deps []target) ([]target, error) {
-	var ordered []target
-
-	satisfied := func(dep target) bool {
-		for i := range dep.Needed {
-			found := false
-			for j := range ordered {
-				if ordered[j].Name == dep.Needed[i] {
-					found = true
+		var images []string
+		if parts[0] == "from" {
+			for _, part := range parts[1:] {
+				if !strings.HasPrefix(part, "--") {
+					images = append(images, part)
 					break
 				}
 			}
-			if !found {
-				return false
+		}
+		for _, part := range parts {
+			if image, ok := strings.CutPrefix(part, "--from="); ok {
+				images = append(images, image)
+			} else if options, ok := strings.CutPrefix(part, "--mount="); ok {
+				for _, option := range strings.Split(options, ",") {
+					if image, ok := strings.CutPrefix(option, "from="); ok {
+						images = append(images, image)
+					}
+				}
 			}
 		}
-		return true
-	}
 
-	for len(deps) > 0 {
-		var batch []target
-		var remaining []target
-		for d := range deps {
-			if satisfied(deps[d]) {
-				batch = append(batch, deps[d])
-			} else {
-				remaining = append(remaining, deps[d])
+		for _, image := range images {
+			if contempt.HasRegistry(image) {
+				res = append(res, image)
 			}
 		}
-		deps = remaining
+	}
 
-		if len(batch) == 0 {
-			return nil, fmt.Errorf("could not find any satisfied dependencies - is there a loop? Pending: %#v, selected: %#v", deps, ordered)
-		}
+	return res, scanner.Err()
+}
 
-		slices.SortFunc(batch, func(i, j target) int {
-			if i.Name < j.Name {
-				return 1
-			} else if i.Name > j.Name {
-				return -1
-			} else {
-				return 0
-			}
-		})
-		ordered = append(ordered, batch...)
+// orderDependencies sorts the targets so that each one comes after all of its dependencies.
+func orderDependencies(deps []target) ([]target, error) {
+	targets := make(map[string]target)
+	needed := make(map[string][]string)
+	for i := range deps {
+		targets[deps[i].Name] = deps[i]
+		needed[deps[i].Name] = deps[i].Needed
 	}
 
+	levels, err := contempt.ResolveLevels(needed)
+	if err != nil {
+		return nil, err
+	}
+
+	var ordered []target
+	for l := range levels {
+		slices.Reverse(levels[l])
+		for _, name := range levels[l] {
+			ordered = append(ordered, targets[name])
+		}
+	}
 	return ordered, nil
 }
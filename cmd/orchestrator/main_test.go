@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadImages(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		want       []string
+	}{
+		{
+			name:       "from instruction",
+			dockerfile: "FROM reg.example.com/base@sha256:abcd\nRUN true\n",
+			want:       []string{"reg.example.com/base@sha256:abcd"},
+		},
+		{
+			name:       "from instruction with platform flag and stage name",
+			dockerfile: "FROM --platform=$BUILDPLATFORM reg.example.com/golang:latest AS build\n",
+			want:       []string{"reg.example.com/golang:latest"},
+		},
+		{
+			name:       "copy from image",
+			dockerfile: "FROM reg.example.com/base\nCOPY --from=reg.example.com/certs:latest /etc/ssl /etc/ssl\n",
+			want:       []string{"reg.example.com/base", "reg.example.com/certs:latest"},
+		},
+		{
+			name:       "run mount from image",
+			dockerfile: "FROM reg.example.com/base\nRUN --mount=type=bind,from=localhost:5000/tools,target=/tools /tools/install\n",
+			want:       []string{"reg.example.com/base", "localhost:5000/tools"},
+		},
+		{
+			name: "build stages and unqualified images are ignored",
+			dockerfile: "FROM golang AS build\nFROM reg.example.com/base\n" +
+				"COPY --from=build /out /out\nRUN --mount=type=cache,target=/cache --mount=from=build,target=/b true\n",
+			want: []string{"reg.example.com/base"},
+		},
+		{
+			name:       "instructions are case insensitive",
+			dockerfile: "from Reg.Example.com/Base\ncopy --FROM=reg.example.com/other /a /b\n",
+			want:       []string{"reg.example.com/base", "reg.example.com/other"},
+		},
+		{
+			name:       "comments and short lines are ignored",
+			dockerfile: "# FROM reg.example.com/comment\n\nFROM\nFROM reg.example.com/base\n",
+			want:       []string{"reg.example.com/base"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := fstest.MapFS{"project/Dockerfile": {Data: []byte(tt.dockerfile)}}
+			got, err := readImages(s, "project/Dockerfile")
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReadImages_missingFile(t *testing.T) {
+	_, err := readImages(fstest.MapFS{}, "project/Dockerfile")
+	assert.Error(t, err)
+}
+
+func TestOrderDependencies(t *testing.T) {
+	tests := []struct {
+		name    string
+		deps    []target
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "dependencies come first",
+			deps: []target{
+				{Name: "app", Needed: []string{"golang", "base"}},
+				{Name: "golang", Needed: []string{"base"}},
+				{Name: "base"},
+			},
+			want: []string{"base", "golang", "app"},
+		},
+		{
+			name: "each level is in reverse alphabetical order",
+			deps: []target{
+				{Name: "a", Needed: []string{"base"}},
+				{Name: "c", Needed: []string{"base"}},
+				{Name: "b", Needed: []string{"base"}},
+				{Name: "base"},
+			},
+			want: []string{"base", "c", "b", "a"},
+		},
+		{
+			name: "loop",
+			deps: []target{
+				{Name: "a", Needed: []string{"b"}},
+				{Name: "b", Needed: []string{"a"}},
+			},
+			wantErr: "a -> b -> a",
+		},
+		{
+			name: "missing dependency",
+			deps: []target{
+				{Name: "a", Needed: []string{"missing"}},
+			},
+			wantErr: "missing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := orderDependencies(tt.deps)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			var names []string
+			for i := range got {
+				names = append(names, got[i].Name)
+			}
+			assert.Equal(t, tt.want, names)
+		})
+	}
+}
+
+func TestOrderDependencies_keepsTargetDetails(t *testing.T) {
+	got, err := orderDependencies([]target{
+		{Name: "app", Needed: []string{"base"}, Images: []string{"reg.example.com/app"}, Push: true},
+		{Name: "base", Platforms: []string{"linux/amd64"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []target{
+		{Name: "base", Platforms: []string{"linux/amd64"}},
+		{Name: "app", Needed: []string{"base"}, Images: []string{"reg.example.com/app"}, Push: true},
+	}, got)
+}
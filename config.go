@@ -0,0 +1,101 @@
+package contempt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectConfigName is the name of the optional file next to a project's template that configures how it is built.
+const ProjectConfigName = "contempt.yaml"
+
+// ProjectConfig configures how a project's image is built and pushed. All settings are optional.
+type ProjectConfig struct {
+	// Images are the names of the images to build, instead of "<registry>/<project>".
+	Images []string `yaml:"images,omitempty" json:"images,omitempty"`
+	// Tags are extra tags to apply to each image, in addition to "latest".
+	Tags []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	// BuildArgs are passed to the build as build-time variables.
+	BuildArgs map[string]string `yaml:"build_args,omitempty" json:"build_args,omitempty"`
+	// Target is the build stage to build, instead of the final one.
+	Target string `yaml:"target,omitempty" json:"target,omitempty"`
+	// Platforms are the platforms to build the image for, e.g. "linux/arm64".
+	Platforms []string `yaml:"platforms,omitempty" json:"platforms,omitempty"`
+	// Context is the directory to use as the build context, relative to the project directory.
+	Context string `yaml:"context,omitempty" json:"context,omitempty"`
+	// Push is whether the image should be pushed after it is built. Defaults to true.
+	Push *bool `yaml:"push,omitempty" json:"push,omitempty"`
+}
+
+// LoadProjectConfig reads the config file in the given project directory. If there isn't one, the default (empty)
+// config is returned.
+func LoadProjectConfig(dir string) (ProjectConfig, error) {
+	var config ProjectConfig
+
+	content, err := os.ReadFile(filepath.Join(dir, ProjectConfigName))
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	} else if err != nil {
+		return config, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return config, fmt.Errorf("unable to parse %s: %v", filepath.Join(dir, ProjectConfigName), err)
+	}
+
+	if filepath.IsAbs(config.Context) {
+		return config, fmt.Errorf("invalid context in %s: must be relative to the project", filepath.Join(dir, ProjectConfigName))
+	}
+	for _, image := range config.Images {
+		if image == "" || strings.Contains(image, "@") || strings.LastIndexByte(image, ':') > strings.LastIndexByte(image, '/') {
+			return config, fmt.Errorf("invalid image %q in %s: must not include a tag or digest", image, filepath.Join(dir, ProjectConfigName))
+		}
+	}
+	for _, tag := range config.Tags {
+		if tag == "" || strings.ContainsAny(tag, ":/@") {
+			return config, fmt.Errorf("invalid tag %q in %s", tag, filepath.Join(dir, ProjectConfigName))
+		}
+	}
+
+	return config, nil
+}
+
+// ImageNames returns the fully-qualified names of all images the project should be tagged as: each configured image
+// (or "<registry>/<project>" if none are configured) with the "latest" tag, followed by each image with each extra
+// tag.
+func (c ProjectConfig) ImageNames(registry, project string) []string {
+	images := c.Images
+	if len(images) == 0 {
+		images = []string{fmt.Sprintf("%s/%s", registry, project)}
+	}
+
+	res := append([]string{}, images...)
+	for _, tag := range c.Tags {
+		for _, image := range images {
+			res = append(res, fmt.Sprintf("%s:%s", image, tag))
+		}
+	}
+	return res
+}
+
+// ShouldPush determines whether the project's images should be pushed after they are built.
+func (c ProjectConfig) ShouldPush() bool {
+	return c.Push == nil || *c.Push
+}
+
+// ContextDir returns the build context for a project rendered into the given directory, or an empty string if the
+// project directory itself should be used.
+func (c ProjectConfig) ContextDir(dir string) string {
+	if c.Context == "" {
+		return ""
+	}
+	return filepath.Join(dir, c.Context)
+}
@@ -0,0 +1,106 @@
+package contempt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadProjectConfig(t *testing.T) {
+	push := false
+
+	tests := []struct {
+		name       string
+		content    string
+		want       ProjectConfig
+		wantImages []string
+		wantPush   bool
+		wantErr    string
+	}{
+		{
+			name:       "no config",
+			wantImages: []string{"reg.example.com/app"},
+			wantPush:   true,
+		},
+		{
+			name:       "empty config",
+			content:    "",
+			wantImages: []string{"reg.example.com/app"},
+			wantPush:   true,
+		},
+		{
+			name: "all settings",
+			content: `images: [ghcr.io/example/app, reg.example.com/app]
+tags: ["1", "1.2"]
+build_args:
+  VERSION: "1.2.3"
+target: runtime
+platforms: [linux/amd64, linux/arm64]
+context: ..
+push: false
+`,
+			want: ProjectConfig{
+				Images:    []string{"ghcr.io/example/app", "reg.example.com/app"},
+				Tags:      []string{"1", "1.2"},
+				BuildArgs: map[string]string{"VERSION": "1.2.3"},
+				Target:    "runtime",
+				Platforms: []string{"linux/amd64", "linux/arm64"},
+				Context:   "..",
+				Push:      &push,
+			},
+			wantImages: []string{
+				"ghcr.io/example/app",
+				"reg.example.com/app",
+				"ghcr.io/example/app:1",
+				"reg.example.com/app:1",
+				"ghcr.io/example/app:1.2",
+				"reg.example.com/app:1.2",
+			},
+			wantPush: false,
+		},
+		{
+			name:    "unknown setting",
+			content: "image: ghcr.io/example/app\n",
+			wantErr: "field image not found",
+		},
+		{
+			name:    "image with tag",
+			content: "images: [ghcr.io/example/app:1]\n",
+			wantErr: `invalid image "ghcr.io/example/app:1"`,
+		},
+		{
+			name:    "invalid tag",
+			content: "tags: [\"app:1\"]\n",
+			wantErr: `invalid tag "app:1"`,
+		},
+		{
+			name:    "absolute context",
+			content: "context: /etc\n",
+			wantErr: "invalid context",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "app")
+			require.NoError(t, os.MkdirAll(dir, 0755))
+			if tt.name != "no config" {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, ProjectConfigName), []byte(tt.content), 0644))
+			}
+
+			got, err := LoadProjectConfig(dir)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantImages, got.ImageNames("reg.example.com", "app"))
+			assert.Equal(t, tt.wantPush, got.ShouldPush())
+		})
+	}
+}
@@ -5,9 +5,13 @@ go 1.25.0
 require (
 	github.com/csmith/envflag/v2 v2.0.0
 	github.com/csmith/latest/v3 v3.0.1
+	github.com/hashicorp/go-version v1.8.0
+	github.com/klauspost/compress v1.18.4
 	github.com/stretchr/testify v1.11.1
 	golang.org/x/exp v0.0.0-20260410095643-746e56fc9e2f
+	golang.org/x/sync v0.20.0
 	gopkg.in/osteele/liquid.v1 v1.2.4
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -23,8 +27,6 @@ require (
 	github.com/docker/docker-credential-helpers v0.9.4 // indirect
 	github.com/goccy/go-yaml v1.19.2 // indirect
 	github.com/google/go-containerregistry v0.20.7 // indirect
-	github.com/hashicorp/go-version v1.8.0 // indirect
-	github.com/klauspost/compress v1.18.4 // indirect
 	github.com/mitchellh/go-homedir v1.1.0 // indirect
 	github.com/opencontainers/go-digest v1.0.0 // indirect
 	github.com/opencontainers/image-spec v1.1.1 // indirect
@@ -34,8 +36,6 @@ require (
 	github.com/sirupsen/logrus v1.9.3 // indirect
 	github.com/vbatts/tar-split v0.12.2 // indirect
 	golang.org/x/net v0.47.0 // indirect
-	golang.org/x/sync v0.20.0 // indirect
 	golang.org/x/sys v0.38.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,157 @@
+package contempt
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ResolveLevels groups projects into levels, given a map of each project's name to the names of the projects it
+// depends on. Each level contains only projects whose dependencies are all in earlier levels, and is sorted
+// alphabetically.
+//
+// If any project depends on a project that isn't in the map, or the dependencies contain a loop, an error describing
+// each problem is returned. Loops are described by the path around them, e.g. "a -> b -> c -> a".
+func ResolveLevels(deps map[string][]string) ([][]string, error) {
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		for _, dep := range deps[name] {
+			if _, ok := deps[dep]; !ok {
+				errs = append(errs, fmt.Errorf("project %s depends on %s, which does not exist", name, dep))
+			}
+		}
+	}
+
+	for _, component := range stronglyConnectedComponents(names, deps) {
+		if len(component) > 1 || slices.Contains(deps[component[0]], component[0]) {
+			errs = append(errs, fmt.Errorf("dependency loop: %s", strings.Join(findLoop(component, deps), " -> ")))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	levels := make(map[string]int)
+	var levelOf func(name string) int
+	levelOf = func(name string) int {
+		if level, ok := levels[name]; ok {
+			return level
+		}
+
+		level := 0
+		for _, dep := range deps[name] {
+			level = max(level, levelOf(dep)+1)
+		}
+		levels[name] = level
+		return level
+	}
+
+	var res [][]string
+	for _, name := range names {
+		level := levelOf(name)
+		for len(res) <= level {
+			res = append(res, nil)
+		}
+		res[level] = append(res[level], name)
+	}
+	return res, nil
+}
+
+// stronglyConnectedComponents finds the strongly connected components of the dependency graph using Tarjan's
+// algorithm. Dependencies on projects that aren't in the graph are ignored. The projects within each component are
+// sorted alphabetically.
+func stronglyConnectedComponents(names []string, deps map[string][]string) [][]string {
+	var (
+		index   = make(map[string]int)
+		lowLink = make(map[string]int)
+		onStack = make(map[string]bool)
+		stack   []string
+		res     [][]string
+		visit   func(name string)
+		counter int
+	)
+
+	visit = func(name string) {
+		index[name] = counter
+		lowLink[name] = counter
+		counter++
+		stack = append(stack, name)
+		onStack[name] = true
+
+		for _, dep := range deps[name] {
+			if _, ok := deps[dep]; !ok {
+				continue
+			}
+
+			if _, visited := index[dep]; !visited {
+				visit(dep)
+				lowLink[name] = min(lowLink[name], lowLink[dep])
+			} else if onStack[dep] {
+				lowLink[name] = min(lowLink[name], index[dep])
+			}
+		}
+
+		if lowLink[name] == index[name] {
+			var component []string
+			for {
+				member := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[member] = false
+				component = append(component, member)
+				if member == name {
+					break
+				}
+			}
+			sort.Strings(component)
+			res = append(res, component)
+		}
+	}
+
+	for _, name := range names {
+		if _, visited := index[name]; !visited {
+			visit(name)
+		}
+	}
+	return res
+}
+
+// findLoop returns the shortest path from the first project in a strongly connected component back to itself,
+// following only dependencies within the component. The first project is repeated at the end of the path.
+func findLoop(component []string, deps map[string][]string) []string {
+	start := component[0]
+	previous := map[string]string{}
+	queue := []string{start}
+
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		for _, dep := range deps[name] {
+			if dep == start {
+				path := []string{start}
+				for n := name; n != start; n = previous[n] {
+					path = append(path, n)
+				}
+				path = append(path, start)
+				slices.Reverse(path)
+				return path
+			}
+
+			if _, seen := previous[dep]; !seen && slices.Contains(component, dep) {
+				previous[dep] = name
+				queue = append(queue, dep)
+			}
+		}
+	}
+
+	return append(component, start)
+}
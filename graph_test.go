@@ -0,0 +1,94 @@
+package contempt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveLevels(t *testing.T) {
+	tests := []struct {
+		name    string
+		deps    map[string][]string
+		want    [][]string
+		wantErr string
+	}{
+		{
+			name: "no projects",
+			deps: map[string][]string{},
+			want: nil,
+		},
+		{
+			name: "levels",
+			deps: map[string][]string{
+				"alpine": {},
+				"golang": {"alpine"},
+				"gitea":  {"golang", "alpine"},
+				"irc":    {"alpine"},
+				"base":   nil,
+			},
+			want: [][]string{{"alpine", "base"}, {"golang", "irc"}, {"gitea"}},
+		},
+		{
+			name: "missing project",
+			deps: map[string][]string{
+				"alpine": {},
+				"golang": {"alpine", "apline"},
+			},
+			wantErr: "project golang depends on apline, which does not exist",
+		},
+		{
+			name: "loop",
+			deps: map[string][]string{
+				"a": {"b"},
+				"b": {"c"},
+				"c": {"a", "d"},
+				"d": {},
+				"e": {"a"},
+			},
+			wantErr: "dependency loop: a -> b -> c -> a",
+		},
+		{
+			name: "shortest path around loop",
+			deps: map[string][]string{
+				"a": {"b", "d"},
+				"b": {"c"},
+				"c": {"a"},
+				"d": {"a"},
+			},
+			wantErr: "dependency loop: a -> d -> a",
+		},
+		{
+			name: "self dependency",
+			deps: map[string][]string{
+				"a": {"a"},
+			},
+			wantErr: "dependency loop: a -> a",
+		},
+		{
+			name: "multiple problems",
+			deps: map[string][]string{
+				"a": {"b"},
+				"b": {"a"},
+				"c": {"d"},
+				"d": {"c", "x"},
+			},
+			wantErr: "project d depends on x, which does not exist\n" +
+				"dependency loop: a -> b -> a\n" +
+				"dependency loop: c -> d -> c",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveLevels(tt.deps)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package internal
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// RunCommand logs and then runs the given command, passing through its stdout and stderr.
+func RunCommand(cmd *exec.Cmd) error {
+	log.Printf("Running \"%s\"", strings.Join(cmd.Args, "\" \""))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// CommandOutput logs and then runs the given command, returning its stdout. Stderr is passed through.
+func CommandOutput(cmd *exec.Cmd) ([]byte, error) {
+	log.Printf("Running \"%s\"", strings.Join(cmd.Args, "\" \""))
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
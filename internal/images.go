@@ -0,0 +1,10 @@
+package internal
+
+import "strings"
+
+// HasRegistry determines whether the image name starts with a registry host, e.g. "ghcr.io/csmith/contempt" or
+// "localhost:5000/alpine", rather than being relative to a default registry.
+func HasRegistry(name string) bool {
+	host, _, ok := strings.Cut(name, "/")
+	return ok && (strings.ContainsAny(host, ".:") || host == "localhost")
+}
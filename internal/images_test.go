@@ -0,0 +1,29 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasRegistry(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"alpine", false},
+		{"csmith/contempt", false},
+		{"library/alpine:3.20", false},
+		{"ghcr.io/csmith/contempt", true},
+		{"reg.c5h.io/alpine:latest", true},
+		{"localhost/alpine", true},
+		{"localhost:5000/alpine", true},
+		{"127.0.0.1:5000/alpine@sha256:abcd", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, HasRegistry(tt.name))
+		})
+	}
+}
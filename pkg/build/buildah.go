@@ -0,0 +1,60 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/csmith/contempt/internal"
+)
+
+// Buildah builds and pushes images using buildah.
+type Buildah struct {
+	manifests manifestLists
+}
+
+func (b *Buildah) Version() error {
+	return b.run("--version")
+}
+
+func (b *Buildah) Build(dir, image string, options Options) error {
+	args := []string{"bud", "--timestamp", "0", "--layers"}
+	if options.multiPlatform() {
+		if err := removeManifest(b.run, image); err != nil {
+			return err
+		}
+		b.manifests.add(image, options.Tags...)
+		args = append(args, "--manifest", image)
+	} else {
+		for _, tag := range append([]string{image}, options.Tags...) {
+			args = append(args, "--tag", tag)
+		}
+	}
+	return b.run(append(args, options.args(dir)...)...)
+}
+
+func (b *Buildah) Push(image string) (string, error) {
+	path, err := digestFile()
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(path)
+
+	if manifest, ok := b.manifests.source(image); ok {
+		err = b.run("manifest", "push", "--all", "--digestfile", path, manifest, fmt.Sprintf("docker://%s", image))
+	} else {
+		err = b.run("push", "--digestfile", path, image)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return readDigestFile(path)
+}
+
+func (b *Buildah) run(args ...string) error {
+	return internal.RunCommand(exec.Command(
+		"/usr/bin/buildah",
+		args...,
+	))
+}
@@ -0,0 +1,162 @@
+package build
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Builder builds container images from rendered project directories, and pushes them to registries.
+type Builder interface {
+	// Version checks that the builder's tooling is installed and working.
+	Version() error
+	// Build builds the Dockerfile or Containerfile in the given directory, and tags the result with the given image
+	// name, and any extra tags in the options.
+	Build(dir, image string, options Options) error
+	// Push pushes the given image to its registry, and returns the digest of the pushed manifest.
+	Push(image string) (string, error)
+}
+
+// Options are optional settings for a build.
+type Options struct {
+	// Tags are additional image names (including tags) to apply to the built image.
+	Tags []string
+	// BuildArgs are passed to the build as build-time variables.
+	BuildArgs map[string]string
+	// Target is the name of the build stage to build, instead of the final one.
+	Target string
+	// Platforms are the platforms to build the image for, e.g. "linux/arm64". If more than one is given, buildah and
+	// podman build a multi-platform image, while docker returns an error.
+	Platforms []string
+	// Context is the directory to use as the build context, instead of the directory containing the Dockerfile.
+	Context string
+}
+
+// args returns the command line arguments common to all builders for the given options, ending with the build
+// context. The Dockerfile or Containerfile in dir is always passed explicitly, as not every builder looks for a
+// Containerfile by default.
+func (o Options) args(dir string) []string {
+	var args []string
+	for _, name := range slices.Sorted(maps.Keys(o.BuildArgs)) {
+		args = append(args, "--build-arg", fmt.Sprintf("%s=%s", name, o.BuildArgs[name]))
+	}
+	if o.Target != "" {
+		args = append(args, "--target", o.Target)
+	}
+	if len(o.Platforms) > 0 {
+		args = append(args, "--platform", strings.Join(o.Platforms, ","))
+	}
+	args = append(args, "--file", containerfile(dir))
+	if o.Context == "" {
+		return append(args, dir)
+	}
+	return append(args, o.Context)
+}
+
+// multiPlatform determines whether the options require a multi-platform image to be built.
+func (o Options) multiPlatform() bool {
+	return len(o.Platforms) > 1
+}
+
+// containerfile returns the path of the Containerfile in the given directory if there is one, otherwise the path of
+// its Dockerfile.
+func containerfile(dir string) string {
+	if _, err := os.Stat(filepath.Join(dir, "Containerfile")); err == nil {
+		return filepath.Join(dir, "Containerfile")
+	}
+	return filepath.Join(dir, "Dockerfile")
+}
+
+// removeManifest removes the local manifest list with the given name, if there is one, using the given function to
+// run buildah or podman. Builds with `--manifest` add to an existing list, so without this, images from earlier runs
+// would be pushed alongside the new ones.
+func removeManifest(run func(args ...string) error, image string) error {
+	if run("manifest", "exists", image) != nil {
+		return nil
+	}
+	if err := run("manifest", "rm", image); err != nil {
+		return fmt.Errorf("unable to remove existing manifest list %s: %v", image, err)
+	}
+	return nil
+}
+
+// manifestLists tracks the images that were built as multi-platform manifest lists by buildah or podman, which must
+// be pushed differently to single images. Extra tags are pushed from the manifest list they were built as.
+type manifestLists struct {
+	mutex   sync.Mutex
+	sources map[string]string
+}
+
+func (m *manifestLists) add(manifest string, tags ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.sources == nil {
+		m.sources = make(map[string]string)
+	}
+	for _, tag := range append([]string{manifest}, tags...) {
+		m.sources[tag] = manifest
+	}
+}
+
+// source returns the manifest list that should be pushed to the given image name, if it was built as one.
+func (m *manifestLists) source(image string) (string, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	manifest, ok := m.sources[image]
+	return manifest, ok
+}
+
+// Names returns the names of all builders that can be passed to New.
+func Names() []string {
+	return []string{"buildah", "podman", "docker"}
+}
+
+// New creates a new Builder by name. See Names for the supported builders.
+func New(name string) (Builder, error) {
+	switch name {
+	case "buildah":
+		return &Buildah{}, nil
+	case "podman":
+		return &Podman{}, nil
+	case "docker":
+		return &Docker{}, nil
+	default:
+		return nil, fmt.Errorf("unknown builder %q, must be one of: %s", name, strings.Join(Names(), ", "))
+	}
+}
+
+// PushWithRetries pushes the given image, retrying up to the given number of times if the push fails.
+func PushWithRetries(builder Builder, image string, retries int) (string, error) {
+	for r := 0; r <= retries; r++ {
+		digest, err := builder.Push(image)
+		if err == nil {
+			return digest, nil
+		}
+		log.Printf("Failed to push %s [attempt %d/%d]: %v", image, r+1, retries+1, err)
+	}
+	return "", fmt.Errorf("failed to push %s after %d attempts", image, retries+1)
+}
+
+// readDigestFile reads a digest written by a `--digestfile` flag.
+func readDigestFile(path string) (string, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read digest: %v", err)
+	}
+	return strings.TrimSpace(string(bs)), nil
+}
+
+// digestFile returns the path of a new temporary file that can be used with `--digestfile`. The caller is responsible
+// for removing it.
+func digestFile() (string, error) {
+	f, err := os.CreateTemp("", "contempt-digest-*")
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
@@ -0,0 +1,199 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyBuilder fails to push until it has been called the given number of times.
+type flakyBuilder struct {
+	failures int
+	pushes   []string
+}
+
+func (f *flakyBuilder) Version() error {
+	return nil
+}
+
+func (f *flakyBuilder) Build(string, string, Options) error {
+	return nil
+}
+
+func (f *flakyBuilder) Push(image string) (string, error) {
+	f.pushes = append(f.pushes, image)
+	if len(f.pushes) <= f.failures {
+		return "", fmt.Errorf("push failed")
+	}
+	return "sha256:abcd", nil
+}
+
+func TestPushWithRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		failures   int
+		retries    int
+		wantPushes int
+		wantErr    string
+	}{
+		{name: "first attempt", failures: 0, retries: 2, wantPushes: 1},
+		{name: "after retries", failures: 2, retries: 2, wantPushes: 3},
+		{name: "no retries", failures: 1, retries: 0, wantPushes: 1, wantErr: "failed to push reg.example.com/app after 1 attempts"},
+		{name: "too many failures", failures: 3, retries: 2, wantPushes: 3, wantErr: "failed to push reg.example.com/app after 3 attempts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := &flakyBuilder{failures: tt.failures}
+			digest, err := PushWithRetries(builder, "reg.example.com/app", tt.retries)
+			assert.Len(t, builder.pushes, tt.wantPushes)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "sha256:abcd", digest)
+		})
+	}
+}
+
+func TestDigestFile(t *testing.T) {
+	path, err := digestFile()
+	require.NoError(t, err)
+	defer os.Remove(path)
+
+	require.NoError(t, os.WriteFile(path, []byte("sha256:abcd\n"), 0600))
+	digest, err := readDigestFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:abcd", digest)
+
+	require.NoError(t, os.Remove(path))
+	_, err = readDigestFile(path)
+	assert.ErrorContains(t, err, "unable to read digest")
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range Names() {
+		builder, err := New(name)
+		require.NoError(t, err)
+		assert.NotNil(t, builder)
+	}
+
+	_, err := New("kaniko")
+	assert.EqualError(t, err, `unknown builder "kaniko", must be one of: buildah, podman, docker`)
+}
+
+func TestOptions_args(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		options Options
+		want    []string
+	}{
+		{name: "defaults", options: Options{}, want: []string{"--file", filepath.Join(dir, "Dockerfile"), dir}},
+		{
+			name: "all options",
+			options: Options{
+				BuildArgs: map[string]string{"VERSION": "1.2", "ARCH": "arm64"},
+				Target:    "runtime",
+				Platforms: []string{"linux/amd64", "linux/arm64"},
+			},
+			want: []string{
+				"--build-arg", "ARCH=arm64",
+				"--build-arg", "VERSION=1.2",
+				"--target", "runtime",
+				"--platform", "linux/amd64,linux/arm64",
+				"--file", filepath.Join(dir, "Dockerfile"),
+				dir,
+			},
+		},
+		{
+			name:    "context",
+			options: Options{Context: "/src"},
+			want:    []string{"--file", filepath.Join(dir, "Dockerfile"), "/src"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.options.args(dir))
+		})
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Containerfile"), []byte("FROM scratch"), 0600))
+	assert.Equal(t, []string{"--file", filepath.Join(dir, "Containerfile"), dir}, Options{}.args(dir))
+	assert.Equal(t, []string{"--file", filepath.Join(dir, "Containerfile"), "/src"}, Options{Context: "/src"}.args(dir))
+}
+
+func TestManifestLists(t *testing.T) {
+	var m manifestLists
+
+	_, ok := m.source("reg.example.com/app")
+	assert.False(t, ok)
+
+	m.add("reg.example.com/app", "reg.example.com/app:1", "ghcr.io/example/app:1")
+	for _, image := range []string{"reg.example.com/app", "reg.example.com/app:1", "ghcr.io/example/app:1"} {
+		manifest, ok := m.source(image)
+		assert.True(t, ok)
+		assert.Equal(t, "reg.example.com/app", manifest)
+	}
+
+	_, ok = m.source("reg.example.com/other")
+	assert.False(t, ok)
+}
+
+func TestRemoveManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		exists   bool
+		rmErr    error
+		wantRuns []string
+		wantErr  string
+	}{
+		{name: "missing", wantRuns: []string{"manifest exists app"}},
+		{name: "existing", exists: true, wantRuns: []string{"manifest exists app", "manifest rm app"}},
+		{
+			name:     "failed",
+			exists:   true,
+			rmErr:    fmt.Errorf("exit status 125"),
+			wantRuns: []string{"manifest exists app", "manifest rm app"},
+			wantErr:  "unable to remove existing manifest list app: exit status 125",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var runs []string
+			err := removeManifest(func(args ...string) error {
+				runs = append(runs, strings.Join(args, " "))
+				switch args[1] {
+				case "exists":
+					if !tt.exists {
+						return fmt.Errorf("exit status 1")
+					}
+				case "rm":
+					return tt.rmErr
+				}
+				return nil
+			}, "app")
+
+			assert.Equal(t, tt.wantRuns, runs)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDocker_multiPlatform(t *testing.T) {
+	err := (&Docker{}).Build(t.TempDir(), "reg.example.com/app", Options{Platforms: []string{"linux/amd64", "linux/arm64"}})
+	assert.EqualError(t, err, "unable to build reg.example.com/app for platforms linux/amd64, linux/arm64: docker can only build for one platform, use buildah or podman instead")
+}
@@ -0,0 +1,77 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/csmith/contempt/internal"
+)
+
+// Docker builds and pushes images using docker.
+//
+// Unlike buildah and podman, docker has no equivalent of `--timestamp 0`, so images built with it include the time
+// they were built and are not reproducible. Docker's classic builder also can't build multi-platform images, so
+// builds for more than one platform are rejected.
+type Docker struct{}
+
+func (d *Docker) Version() error {
+	return d.run("--version")
+}
+
+func (d *Docker) Build(dir, image string, options Options) error {
+	if options.multiPlatform() {
+		return fmt.Errorf("unable to build %s for platforms %s: docker can only build for one platform, use buildah or podman instead", image, strings.Join(options.Platforms, ", "))
+	}
+
+	args := []string{"build"}
+	for _, tag := range append([]string{image}, options.Tags...) {
+		args = append(args, "--tag", tag)
+	}
+	return d.run(append(args, options.args(dir)...)...)
+}
+
+func (d *Docker) Push(image string) (string, error) {
+	if err := d.run("push", image); err != nil {
+		return "", err
+	}
+
+	// Docker doesn't support writing the digest to a file, so we have to query the repo digests after pushing.
+	out, err := internal.CommandOutput(exec.Command(
+		"docker",
+		"image",
+		"inspect",
+		"--format",
+		"{{json .RepoDigests}}",
+		image,
+	))
+	if err != nil {
+		return "", err
+	}
+
+	var digests []string
+	if err := json.Unmarshal(out, &digests); err != nil {
+		return "", fmt.Errorf("unable to parse repo digests: %v", err)
+	}
+
+	repo := image
+	if index := strings.LastIndexByte(repo, ':'); index > strings.LastIndexByte(repo, '/') {
+		repo = repo[:index]
+	}
+
+	for i := range digests {
+		if name, digest, ok := strings.Cut(digests[i], "@"); ok && name == repo {
+			return digest, nil
+		}
+	}
+
+	return "", fmt.Errorf("no digest found for %s after pushing", image)
+}
+
+func (d *Docker) run(args ...string) error {
+	return internal.RunCommand(exec.Command(
+		"docker",
+		args...,
+	))
+}
@@ -0,0 +1,60 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/csmith/contempt/internal"
+)
+
+// Podman builds and pushes images using podman.
+type Podman struct {
+	manifests manifestLists
+}
+
+func (p *Podman) Version() error {
+	return p.run("--version")
+}
+
+func (p *Podman) Build(dir, image string, options Options) error {
+	args := []string{"build", "--timestamp", "0", "--layers"}
+	if options.multiPlatform() {
+		if err := removeManifest(p.run, image); err != nil {
+			return err
+		}
+		p.manifests.add(image, options.Tags...)
+		args = append(args, "--manifest", image)
+	} else {
+		for _, tag := range append([]string{image}, options.Tags...) {
+			args = append(args, "--tag", tag)
+		}
+	}
+	return p.run(append(args, options.args(dir)...)...)
+}
+
+func (p *Podman) Push(image string) (string, error) {
+	path, err := digestFile()
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(path)
+
+	if manifest, ok := p.manifests.source(image); ok {
+		err = p.run("manifest", "push", "--all", "--digestfile", path, manifest, fmt.Sprintf("docker://%s", image))
+	} else {
+		err = p.run("push", "--digestfile", path, image)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return readDigestFile(path)
+}
+
+func (p *Podman) run(args ...string) error {
+	return internal.RunCommand(exec.Command(
+		"podman",
+		args...,
+	))
+}
@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Cache stores the results of lookups on disk, so that they can be reused across runs. A nil *Cache is valid, and
+// never contains any entries.
+type Cache struct {
+	dir     string
+	refresh bool
+	now     func() time.Time
+}
+
+// New creates a new cache that stores its entries in the given directory. If refresh is true, existing entries are
+// never returned, but new entries are still stored.
+func New(dir string, refresh bool) *Cache {
+	return &Cache{
+		dir:     dir,
+		refresh: refresh,
+		now:     time.Now,
+	}
+}
+
+type entry struct {
+	Key    string          `json:"key"`
+	Stored time.Time       `json:"stored"`
+	Value  json.RawMessage `json:"value"`
+}
+
+// Get looks up the entry with the given key in the given namespace, and if it was stored less than ttl ago,
+// unmarshals it into value and returns true. Missing, expired or unreadable entries are treated the same, and
+// result in false being returned.
+func (c *Cache) Get(namespace, key string, ttl time.Duration, value any) bool {
+	if c == nil || c.refresh {
+		return false
+	}
+
+	bs, err := os.ReadFile(c.path(namespace, key))
+	if err != nil {
+		return false
+	}
+
+	var e entry
+	if err := json.Unmarshal(bs, &e); err != nil || e.Key != key || c.now().Sub(e.Stored) > ttl {
+		return false
+	}
+
+	return json.Unmarshal(e.Value, value) == nil
+}
+
+// Put stores the given value under the key in the given namespace, replacing any existing entry.
+func (c *Cache) Put(namespace, key string, value any) error {
+	if c == nil {
+		return nil
+	}
+
+	v, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	bs, err := json.Marshal(entry{
+		Key:    key,
+		Stored: c.now(),
+		Value:  v,
+	})
+	if err != nil {
+		return err
+	}
+
+	path := c.path(namespace, key)
+	if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0755)); err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it, so concurrent readers never see a partial entry.
+	f, err := os.CreateTemp(filepath.Dir(path), ".entry-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(bs); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (c *Cache) path(namespace, key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, namespace, hex.EncodeToString(hash[:])+".json")
+}
@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := New(t.TempDir(), false)
+	c.now = func() time.Time { return now }
+
+	var res map[string]string
+	assert.False(t, c.Get("ns", "key", time.Hour, &res), "missing entries are not found")
+
+	assert.NoError(t, c.Put("ns", "key", map[string]string{"a": "b"}))
+	assert.True(t, c.Get("ns", "key", time.Hour, &res), "fresh entries are found")
+	assert.Equal(t, map[string]string{"a": "b"}, res)
+
+	assert.False(t, c.Get("other", "key", time.Hour, &res), "entries are separated by namespace")
+	assert.False(t, c.Get("ns", "other", time.Hour, &res), "entries are separated by key")
+
+	now = now.Add(2 * time.Hour)
+	assert.False(t, c.Get("ns", "key", time.Hour, &res), "expired entries are not found")
+	assert.True(t, c.Get("ns", "key", 3*time.Hour, &res), "entries are found within a longer ttl")
+}
+
+func TestCache_refresh(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, New(dir, true).Put("ns", "key", "value"))
+
+	var res string
+	assert.False(t, New(dir, true).Get("ns", "key", time.Hour, &res), "entries are ignored when refreshing")
+	assert.True(t, New(dir, false).Get("ns", "key", time.Hour, &res), "entries written while refreshing are stored")
+	assert.Equal(t, "value", res)
+}
+
+func TestCache_nil(t *testing.T) {
+	var c *Cache
+	var res string
+	assert.NoError(t, c.Put("ns", "key", "value"))
+	assert.False(t, c.Get("ns", "key", time.Hour, &res))
+}
@@ -0,0 +1,158 @@
+package commit
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/csmith/contempt/internal"
+	"github.com/csmith/contempt/pkg/materials"
+)
+
+// DefaultMessage is the default template used to generate commit messages.
+const DefaultMessage = "[{{.Project}}] {{.Summary}}"
+
+// Committer commits generated files to a git repository.
+type Committer struct {
+	dir         string
+	message     *template.Template
+	authorName  string
+	authorEmail string
+	trailers    []string
+}
+
+// Option configures optional behaviour of a Committer.
+type Option func(*Committer)
+
+// WithAuthor sets the name and email address used as both the author and committer of commits.
+func WithAuthor(name, email string) Option {
+	return func(c *Committer) {
+		c.authorName = name
+		c.authorEmail = email
+	}
+}
+
+// WithTrailers adds trailers (e.g. "Signed-off-by: ...") to the end of every commit message.
+func WithTrailers(trailers ...string) Option {
+	return func(c *Committer) {
+		c.trailers = append(c.trailers, trailers...)
+	}
+}
+
+// MessageData is the data passed to the commit message template.
+type MessageData struct {
+	// Project is the name of the project that was changed.
+	Project string
+	// Changes is the list of materials that changed, sorted by material name.
+	Changes []materials.Change
+	// Summary is a human-readable summary of the changes, as returned by FormatChanges.
+	Summary string
+}
+
+// New creates a new Committer that will operate on the git repository containing dir, using the given text/template
+// to generate commit messages. The template is executed with a MessageData.
+func New(dir, message string, opts ...Option) (*Committer, error) {
+	tpl, err := template.New("message").Parse(message)
+	if err != nil {
+		return nil, fmt.Errorf("invalid commit message template: %v", err)
+	}
+
+	c := &Committer{
+		dir:     dir,
+		message: tpl,
+	}
+	for i := range opts {
+		opts[i](c)
+	}
+	return c, nil
+}
+
+// Version checks that git is installed and working.
+func (c *Committer) Version() error {
+	return c.run("--version")
+}
+
+// Commit stages and commits the given file (relative to the committer's directory), with a message describing the
+// given changes.
+func (c *Committer) Commit(project, file string, changes []materials.Change) error {
+	message, err := c.Message(project, changes)
+	if err != nil {
+		return err
+	}
+
+	if err := c.run("-C", c.dir, "add", file); err != nil {
+		return err
+	}
+
+	return c.run("-C", c.dir, "commit", "--no-gpg-sign", "-m", message, file)
+}
+
+// Message returns the commit message that would be used for the given changes.
+func (c *Committer) Message(project string, changes []materials.Change) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := c.message.Execute(buf, MessageData{
+		Project: project,
+		Changes: changes,
+		Summary: FormatChanges(changes),
+	}); err != nil {
+		return "", fmt.Errorf("unable to generate commit message: %v", err)
+	}
+
+	if len(c.trailers) > 0 {
+		buf.WriteString("\n\n")
+		buf.WriteString(strings.Join(c.trailers, "\n"))
+	}
+
+	return buf.String(), nil
+}
+
+func (c *Committer) run(args ...string) error {
+	var config []string
+	if c.authorName != "" {
+		config = append(config, "-c", fmt.Sprintf("user.name=%s", c.authorName))
+	}
+	if c.authorEmail != "" {
+		config = append(config, "-c", fmt.Sprintf("user.email=%s", c.authorEmail))
+	}
+
+	return internal.RunCommand(exec.Command(
+		"git",
+		append(config, args...)...,
+	))
+}
+
+// FormatChanges returns a human-readable summary of the given changes, suitable for use in a commit message.
+func FormatChanges(changes []materials.Change) string {
+	if len(changes) == 0 {
+		return "no detected changes"
+	}
+
+	builder := strings.Builder{}
+
+	if len(changes) > 1 {
+		builder.WriteString(fmt.Sprintf("%d changes\n", len(changes)))
+
+		sort.Slice(changes, func(i, j int) bool {
+			return changes[i].Material < changes[j].Material
+		})
+	}
+
+	for i := range changes {
+		oldVersion := changes[i].Old
+		newVersion := changes[i].New
+		if oldVersion == "" && newVersion == "" {
+			builder.WriteString(fmt.Sprintf("\n%s unknown changes", changes[i].Material))
+		} else if oldVersion == "" {
+			builder.WriteString(fmt.Sprintf("\n%s (unknown)->%.8s", changes[i].Material, newVersion))
+		} else if newVersion == "" {
+			builder.WriteString(fmt.Sprintf("\n%s %.8s->(unknown)", changes[i].Material, oldVersion))
+		} else {
+			builder.WriteString(fmt.Sprintf("\n%s %.12s->%.12s", changes[i].Material, oldVersion, newVersion))
+		}
+	}
+
+	return strings.TrimPrefix(builder.String(), "\n")
+}
@@ -0,0 +1,84 @@
+package commit
+
+import (
+	"testing"
+
+	"github.com/csmith/contempt/pkg/materials"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommitter_Message(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		trailers []string
+		changes  []materials.Change
+		want     string
+		wantErr  bool
+	}{
+		{
+			"Default template with no changes",
+			DefaultMessage,
+			nil,
+			nil,
+			"[project] no detected changes",
+			false,
+		},
+		{
+			"Default template with a single change",
+			DefaultMessage,
+			nil,
+			[]materials.Change{{Material: "image:alpine", Old: "0123456789abcdef", New: "fedcba9876543210"}},
+			"[project] image:alpine 0123456789ab->fedcba987654",
+			false,
+		},
+		{
+			"Default template with multiple changes",
+			DefaultMessage,
+			nil,
+			[]materials.Change{
+				{Material: "image:b", Old: "1", New: "2"},
+				{Material: "image:a", New: "3"},
+			},
+			"[project] 2 changes\n\nimage:a (unknown)->3\nimage:b 1->2",
+			false,
+		},
+		{
+			"Custom template with trailers",
+			"chore({{.Project}}): update {{len .Changes}} materials",
+			[]string{"Signed-off-by: Bot <bot@example.com>", "Co-authored-by: Other <other@example.com>"},
+			[]materials.Change{{Material: "image:alpine", Old: "1", New: "2"}},
+			"chore(project): update 1 materials\n\nSigned-off-by: Bot <bot@example.com>\nCo-authored-by: Other <other@example.com>",
+			false,
+		},
+		{
+			"Template that fails to execute",
+			"{{.Missing}}",
+			nil,
+			nil,
+			"",
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(".", tt.template, WithTrailers(tt.trailers...))
+			assert.NoError(t, err)
+
+			got, err := c.Message("project", tt.changes)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNew_invalidTemplate(t *testing.T) {
+	_, err := New(".", "{{.Project")
+	assert.Error(t, err)
+}
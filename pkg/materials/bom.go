@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"log"
 	"os"
+	"sort"
 	"strings"
 )
 
@@ -32,9 +33,9 @@ func Read(target string) BOM {
 }
 
 type Change struct {
-	Material string
-	Old      string
-	New      string
+	Material string `json:"material"`
+	Old      string `json:"old"`
+	New      string `json:"new"`
 }
 
 func Diff(oldBom, newBom BOM) []Change {
@@ -48,5 +49,8 @@ func Diff(oldBom, newBom BOM) []Change {
 			})
 		}
 	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Material < res[j].Material
+	})
 	return res
 }
@@ -1,6 +1,9 @@
 package template
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/csmith/contempt/pkg/materials"
 	"io"
@@ -10,46 +13,259 @@ import (
 	"reflect"
 	"strings"
 	"text/template"
+	"time"
 )
 
 // Engine is responsible for evaluating templates and producing outputs.
 type Engine struct {
-	logger    *slog.Logger
-	functions template.FuncMap
-	bom       materials.BOM
-	includes  fs.FS
+	logger           *slog.Logger
+	sources          []registeredSource
+	functions        template.FuncMap
+	includes         fs.FS
+	callTimeout      time.Duration
+	executionTimeout time.Duration
+	lockfile         *Lockfile
+	offline          bool
+}
+
+type registeredSource struct {
+	source FunctionSource
+	local  bool
+}
+
+// Option configures optional behaviour of an Engine.
+type Option func(*Engine)
+
+// WithCallTimeout limits how long each call to a template function may take.
+// Functions that take longer have their context cancelled, and the template
+// fails with an error naming the function. A timeout of zero (the default)
+// means calls are only limited by the execution as a whole.
+func WithCallTimeout(timeout time.Duration) Option {
+	return func(e *Engine) {
+		e.callTimeout = timeout
+	}
+}
+
+// WithExecutionTimeout limits how long each template execution may take in
+// total, across all function calls. A timeout of zero (the default) means
+// executions are only limited by the context passed to Execute.
+func WithExecutionTimeout(timeout time.Duration) Option {
+	return func(e *Engine) {
+		e.executionTimeout = timeout
+	}
+}
+
+// WithLockfile records the result of every call to a template function
+// (other than those registered with [Engine.RegisterLocal]) in the given
+// lockfile, along with the materials it used.
+func WithLockfile(lockfile *Lockfile) Option {
+	return func(e *Engine) {
+		e.lockfile = lockfile
+		e.offline = false
+	}
+}
+
+// WithOfflineLockfile answers every call to a template function (other than
+// those registered with [Engine.RegisterLocal]) from the given lockfile,
+// without calling the function itself. Calls that are not in the lockfile
+// fail.
+func WithOfflineLockfile(lockfile *Lockfile) Option {
+	return func(e *Engine) {
+		e.lockfile = lockfile
+		e.offline = true
+	}
 }
 
 // NewEngine creates a new templating engine that will read template includes
 // from the given file system.
-func NewEngine(logger *slog.Logger, includes fs.FS) *Engine {
-	return &Engine{
+func NewEngine(logger *slog.Logger, includes fs.FS, opts ...Option) *Engine {
+	e := &Engine{
 		logger:    logger.With("component", "template.Engine"),
 		includes:  includes,
 		functions: make(template.FuncMap),
 	}
+	for i := range opts {
+		opts[i](e)
+	}
+	return e
 }
 
 // Register registers functions for use in templates. The FunctionSource is
 // given a BomWriter that it should call whenever functions are called that
 // depend on some material.
 //
+// The FunctionSource is called once for each template that is executed, and
+// is given that execution's [Execution] as its BomWriter, so that each
+// execution gathers its own materials. Any state that should be shared
+// between executions (such as caches) must be created outside of the
+// FunctionSource, and must be safe for concurrent use.
+//
 // If Register is called with sources that return functions with conflicting
 // names, later instances of the functions replace earlier ones.
+//
+// Register must not be called concurrently with DryRun or Execute.
 func (e *Engine) Register(source FunctionSource) {
-	functions := source(&engineBomWriter{engine: e})
+	e.register(source, false)
+}
+
+// RegisterLocal registers functions in the same way as [Engine.Register],
+// for functions that only operate on their arguments and never look anything
+// up. Local functions are always called directly, and are never recorded in
+// or answered from a lockfile.
+func (e *Engine) RegisterLocal(source FunctionSource) {
+	e.register(source, true)
+}
 
+func (e *Engine) register(source FunctionSource, local bool) {
+	e.sources = append(e.sources, registeredSource{source: source, local: local})
+
+	functions := source(discardBomWriter{})
 	for i := range functions {
 		e.logger.Debug("registered template function", "name", i)
 		e.functions[i] = functions[i]
 	}
 }
 
+// functionsFor creates a new set of template functions from all registered
+// sources for use in the given execution. Each function is wrapped so that it
+// runs with its own context; see wrap.
+func (e *Engine) functionsFor(execution *Execution) template.FuncMap {
+	res := make(template.FuncMap)
+	for i := range e.sources {
+		functions := e.sources[i].source(execution)
+		for j := range functions {
+			res[j] = e.wrap(execution, j, functions[j], e.sources[i].local)
+		}
+	}
+	return res
+}
+
+var errorType = reflect.TypeFor[error]()
+
+// wrap returns a version of the function f that gives each call its own
+// context (available to the function via the execution), limited by the
+// engine's call timeout. If f can return an error, then it is not called at
+// all once the execution's context is done, and any error it returns after
+// its context has been cancelled is replaced with one naming the call.
+//
+// Unless the function is local, calls are also recorded in or answered from
+// the engine's lockfile, if it has one.
+func (e *Engine) wrap(execution *Execution, name string, f any, local bool) any {
+	fn := reflect.ValueOf(f)
+	if fn.Kind() != reflect.Func {
+		return f
+	}
+
+	t := fn.Type()
+	returnsError := t.NumOut() == 2 && t.Out(1) == errorType
+	locked := e.lockfile != nil && !local
+
+	// fail returns the given error from the function, or panics if the
+	// function can't return errors (which text/template turns into an error).
+	fail := func(err error) []reflect.Value {
+		if !returnsError {
+			panic(err)
+		}
+		return []reflect.Value{reflect.Zero(t.Out(0)), errorValue(err)}
+	}
+
+	return reflect.MakeFunc(t, func(args []reflect.Value) []reflect.Value {
+		callArgs := flattenArgs(args, t.IsVariadic())
+		ctx, finish := execution.startCall(e.callTimeout)
+		defer finish()
+
+		if locked && e.offline {
+			call, err := e.lockfile.lookup(name, callArgs)
+			if err != nil {
+				return fail(err)
+			}
+
+			result := reflect.New(t.Out(0))
+			if err := json.Unmarshal(call.Result, result.Interface()); err != nil {
+				return fail(fmt.Errorf("%s(%s) has an invalid result in the lockfile: %v", name, formatArgs(callArgs), err))
+			}
+
+			for material, version := range call.Materials {
+				execution.Write(material, version)
+			}
+
+			if returnsError {
+				return []reflect.Value{result.Elem(), reflect.Zero(errorType)}
+			}
+			return []reflect.Value{result.Elem()}
+		}
+
+		if returnsError && ctx.Err() != nil {
+			return fail(callError(ctx, name, callArgs, ctx.Err()))
+		}
+
+		var out []reflect.Value
+		if t.IsVariadic() {
+			out = fn.CallSlice(args)
+		} else {
+			out = fn.Call(args)
+		}
+
+		if returnsError && !out[1].IsNil() {
+			if ctx.Err() != nil {
+				out[1] = errorValue(callError(ctx, name, callArgs, out[1].Interface().(error)))
+			}
+			return out
+		}
+
+		if locked {
+			if err := e.lockfile.record(name, callArgs, out[0].Interface(), execution.callMaterials()); err != nil {
+				return fail(fmt.Errorf("%s(%s): %v", name, formatArgs(callArgs), err))
+			}
+		}
+		return out
+	}).Interface()
+}
+
+// flattenArgs converts the arguments passed to a function into a plain slice,
+// expanding any variadic arguments.
+func flattenArgs(args []reflect.Value, variadic bool) []any {
+	res := make([]any, 0, len(args))
+	for i := range args {
+		if variadic && i == len(args)-1 {
+			for j := 0; j < args[i].Len(); j++ {
+				res = append(res, args[i].Index(j).Interface())
+			}
+		} else {
+			res = append(res, args[i].Interface())
+		}
+	}
+	return res
+}
+
+// formatArgs formats arguments for use in error messages.
+func formatArgs(args []any) string {
+	var formatted []string
+	for i := range args {
+		formatted = append(formatted, fmt.Sprintf("%#v", args[i]))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// callError creates an error describing a call to a template function that
+// was stopped because its context was done.
+func callError(ctx context.Context, name string, args []any, err error) error {
+	reason := "was cancelled"
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		reason = "timed out"
+	}
+
+	return fmt.Errorf("%s(%s) %s: %w", name, formatArgs(args), reason, err)
+}
+
+func errorValue(err error) reflect.Value {
+	return reflect.ValueOf(&err).Elem()
+}
+
 // DryRun parses and executes the template at the given path, but wraps all
 // registered functions with no-ops that simply record their arguments.
 func (e *Engine) DryRun(path string) (map[string][][]interface{}, error) {
 	e.logger.Debug("dry run of template", "path", path)
-	tpl := template.New(filepath.Base(path))
 	dryFuncs := template.FuncMap{}
 	calls := make(map[string][][]interface{})
 
@@ -75,90 +291,101 @@ func (e *Engine) DryRun(path string) (map[string][][]interface{}, error) {
 				calls[f] = append(calls[f], args)
 				return 0
 			}
+		} else if out == reflect.Interface {
+			// Functions returning interfaces may return either a string or a list, so return a list with a
+			// single empty string that works with both "index" and "range".
+			dryFuncs[f] = func(args ...interface{}) interface{} {
+				calls[f] = append(calls[f], args)
+				return []string{""}
+			}
 		} else {
 			return nil, fmt.Errorf("template function %s has unsupported return type: %v", f, out)
 		}
 	}
 
-	tpl.Funcs(dryFuncs)
-
-	// Parse includes
-	if _, err := tpl.ParseFS(e.includes, "*.gotpl"); err != nil {
-		if !strings.Contains(err.Error(), "pattern matches no files") {
-			// Urgh.
-			e.logger.Error("failed to parse included templates", "err", err, "dry-run", true)
-			return nil, err
-		}
-	}
-
-	// Parse the actual template
-	if _, err := tpl.ParseFiles(path); err != nil {
-		e.logger.Error("failed to parse template", "path", path, "err", err, "dry-run", true)
+	tpl, err := e.parse(path, dryFuncs, "dry-run", true)
+	if err != nil {
 		return nil, err
 	}
 
-	// Reset the BOM and execute the template
-	e.bom = make(materials.BOM)
-	err := tpl.ExecuteTemplate(io.Discard, filepath.Base(path), nil)
-	if err != nil {
+	// Execute the template
+	if err := tpl.ExecuteTemplate(io.Discard, filepath.Base(path), nil); err != nil {
 		e.logger.Error("failed to execute template", "path", path, "err", err, "dry-run", true)
 		return nil, err
 	}
 
-	return calls, err
+	return calls, nil
 }
 
 // Execute parses the template at the given path, executes it, and writes it to
 // the given writer.
 //
+// The given context is made available to all template functions via their
+// [Execution], limited by any timeouts configured on the engine. If it is
+// cancelled, the template fails at the next function call.
+//
 // As the template is being executed, functions registered with [Register] may
-// call their [BomWriter] to add material to the bill. These materials are
-// collated and returned once the template has been executed.
+// call their [BomWriter] to add material to the bill. Each call to Execute
+// creates a new [Execution] to collect these materials, which are returned
+// once the template has been executed.
 //
-// Because of the way materials are gathered, the Execute method is not thread
-// safe.
-func (e *Engine) Execute(out io.Writer, path string) (materials.BOM, error) {
+// Execute is safe for concurrent use, provided the registered function sources
+// are.
+func (e *Engine) Execute(ctx context.Context, out io.Writer, path string) (materials.BOM, error) {
 	e.logger.Debug("executing template", "path", path)
+
+	if e.executionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.executionTimeout)
+		defer cancel()
+	}
+
+	execution := newExecution(ctx, e.logger, path)
+
+	tpl, err := e.parse(path, e.functionsFor(execution))
+	if err != nil {
+		return nil, err
+	}
+
+	// Execute the template
+	if err := tpl.ExecuteTemplate(out, filepath.Base(path), nil); err != nil {
+		e.logger.Error("failed to execute template", "path", path, "err", err)
+		return nil, err
+	}
+
+	return execution.Materials(), nil
+}
+
+// parse creates a new template using the given functions, and parses both the
+// includes and the template at the given path into it. Any extra arguments are
+// added to log messages.
+func (e *Engine) parse(path string, functions template.FuncMap, logArgs ...any) (*template.Template, error) {
 	tpl := template.New(filepath.Base(path))
-	tpl.Funcs(e.functions)
+	tpl.Funcs(functions)
 
 	// Parse includes
 	if _, err := tpl.ParseFS(e.includes, "*.gotpl"); err != nil {
 		if !strings.Contains(err.Error(), "pattern matches no files") {
 			// Urgh.
-			e.logger.Error("failed to parse included templates", "err", err)
+			e.logger.Error("failed to parse included templates", append([]any{"err", err}, logArgs...)...)
 			return nil, err
 		}
 	}
 
 	// Parse the actual template
 	if _, err := tpl.ParseFiles(path); err != nil {
-		e.logger.Error("failed to parse template", "path", path, "err", err)
+		e.logger.Error("failed to parse template", append([]any{"path", path, "err", err}, logArgs...)...)
 		return nil, err
 	}
 
-	// Reset the BOM and execute the template
-	e.bom = make(materials.BOM)
-	err := tpl.ExecuteTemplate(out, filepath.Base(path), nil)
-	if err != nil {
-		e.logger.Error("failed to execute template", "path", path, "err", err)
-		return nil, err
-	}
-
-	return e.bom, err
-}
-
-type engineBomWriter struct {
-	engine *Engine
-}
-
-func (e *engineBomWriter) Write(material, version string) {
-	e.engine.logger.Debug("gathered material", "material", material, "version", version)
-	e.engine.bom[material] = version
+	return tpl, nil
 }
 
+// FunctionSource creates template functions. It is given a BomWriter that the
+// functions should call whenever they depend on some material.
 type FunctionSource = func(BomWriter) template.FuncMap
 
+// BomWriter records materials used by a template.
 type BomWriter interface {
 	Write(material, version string)
 }
@@ -0,0 +1,204 @@
+package template
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+	tt "text/template"
+	"time"
+
+	"github.com/csmith/contempt/pkg/materials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEngine(opts ...Option) *Engine {
+	e := NewEngine(slog.New(slog.NewTextHandler(io.Discard, nil)), fstest.MapFS{}, opts...)
+	e.Register(func(writer BomWriter) tt.FuncMap {
+		return tt.FuncMap{
+			"material": func(name, version string) string {
+				writer.Write(name, version)
+				return fmt.Sprintf("%s=%s", name, version)
+			},
+			"wait": func(names ...string) (string, error) {
+				<-ContextOf(writer).Done()
+				return "", ContextOf(writer).Err()
+			},
+			"query": func(name string) (any, error) {
+				return []string{name}, nil
+			},
+			"path": func() string {
+				if execution := ExecutionOf(writer); execution != nil {
+					return filepath.Base(execution.Path())
+				}
+				return "unknown"
+			},
+		}
+	})
+	return e
+}
+
+func writeTemplate(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestEngine_Execute(t *testing.T) {
+	path := writeTemplate(t, t.TempDir(), "test.gotpl", `{{material "a" "1"}} {{material "b" "2"}} {{path}}`)
+
+	out := &bytes.Buffer{}
+	bom, err := testEngine().Execute(context.Background(), out, path)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "a=1 b=2 test.gotpl", out.String())
+	assert.Equal(t, materials.BOM{"a": "1", "b": "2"}, bom)
+}
+
+func TestEngine_Execute_concurrent(t *testing.T) {
+	dir := t.TempDir()
+	e := testEngine()
+
+	var paths []string
+	for i := 0; i < 20; i++ {
+		paths = append(paths, writeTemplate(
+			t,
+			dir,
+			fmt.Sprintf("%d.gotpl", i),
+			fmt.Sprintf(`{{material "shared" "%[1]d"}}{{material "only-%[1]d" "%[1]d"}}`, i),
+		))
+	}
+
+	wg := sync.WaitGroup{}
+	boms := make([]materials.BOM, len(paths))
+	errs := make([]error, len(paths))
+	for i := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			boms[i], errs[i] = e.Execute(context.Background(), io.Discard, paths[i])
+		}()
+	}
+	wg.Wait()
+
+	for i := range paths {
+		assert.NoError(t, errs[i])
+		assert.Equal(t, materials.BOM{
+			"shared":                  fmt.Sprintf("%d", i),
+			fmt.Sprintf("only-%d", i): fmt.Sprintf("%d", i),
+		}, boms[i])
+	}
+}
+
+func TestEngine_DryRun(t *testing.T) {
+	path := writeTemplate(t, t.TempDir(), "test.gotpl", `{{material "a" "1"}} {{material "b" "2"}} {{path}}`)
+
+	calls, err := testEngine().DryRun(path)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][][]interface{}{
+		"material": {{"a", "1"}, {"b", "2"}},
+		"path":     {{}},
+	}, calls)
+}
+
+func TestEngine_DryRun_interface(t *testing.T) {
+	path := writeTemplate(t, t.TempDir(), "test.gotpl", `{{index (query "a") 0}} {{range query "b"}}{{.}}{{end}}`)
+
+	calls, err := testEngine().DryRun(path)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][][]interface{}{
+		"query": {{"a"}, {"b"}},
+	}, calls)
+}
+
+func TestEngine_Execute_callTimeout(t *testing.T) {
+	path := writeTemplate(t, t.TempDir(), "test.gotpl", `{{material "a" "1"}} {{wait "x" "y"}}`)
+
+	_, err := testEngine(WithCallTimeout(10*time.Millisecond)).Execute(context.Background(), io.Discard, path)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, `wait("x", "y") timed out`)
+}
+
+func TestEngine_Execute_executionTimeout(t *testing.T) {
+	path := writeTemplate(t, t.TempDir(), "test.gotpl", `{{wait}} {{material "a" "1"}}`)
+
+	_, err := testEngine(WithExecutionTimeout(10*time.Millisecond)).Execute(context.Background(), io.Discard, path)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, `wait() timed out`)
+}
+
+func TestEngine_Execute_cancelled(t *testing.T) {
+	path := writeTemplate(t, t.TempDir(), "test.gotpl", `{{material "a" "1"}} {{wait "x"}}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := testEngine().Execute(ctx, io.Discard, path)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorContains(t, err, `wait("x") was cancelled`)
+}
+
+func TestEngine_Execute_lockfile(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTemplate(t, dir, "test.gotpl", `{{material "a" "1"}} {{material "b" "2"}}`)
+	lockPath := filepath.Join(dir, "contempt.lock")
+
+	lockfile := NewLockfile()
+	_, err := testEngine(WithLockfile(lockfile)).Execute(context.Background(), io.Discard, path)
+	require.NoError(t, err)
+	require.NoError(t, lockfile.Write(lockPath))
+
+	lockfile, err = ReadLockfile(lockPath)
+	require.NoError(t, err)
+	assert.Equal(t, 2, lockfile.Len())
+
+	// An engine whose functions return different results should replay the locked ones instead
+	e := NewEngine(slog.New(slog.NewTextHandler(io.Discard, nil)), fstest.MapFS{}, WithOfflineLockfile(lockfile))
+	e.Register(func(writer BomWriter) tt.FuncMap {
+		return tt.FuncMap{
+			"material": func(name, version string) (string, error) {
+				return "", fmt.Errorf("should not be called")
+			},
+		}
+	})
+
+	out := &bytes.Buffer{}
+	bom, err := e.Execute(context.Background(), out, path)
+	assert.NoError(t, err)
+	assert.Equal(t, "a=1 b=2", out.String())
+	assert.Equal(t, materials.BOM{"a": "1", "b": "2"}, bom)
+
+	// Calls that weren't recorded should fail
+	path = writeTemplate(t, dir, "other.gotpl", `{{material "c" "3"}}`)
+	_, err = e.Execute(context.Background(), io.Discard, path)
+	assert.ErrorContains(t, err, `material("c", "3") is not in the lockfile`)
+}
+
+func TestEngine_RegisterLocal(t *testing.T) {
+	path := writeTemplate(t, t.TempDir(), "test.gotpl", `{{local 1}}`)
+
+	e := testEngine(WithOfflineLockfile(NewLockfile()))
+	e.RegisterLocal(func(writer BomWriter) tt.FuncMap {
+		return tt.FuncMap{
+			"local": func(i int) int {
+				return i + 1
+			},
+		}
+	})
+
+	out := &bytes.Buffer{}
+	_, err := e.Execute(context.Background(), out, path)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", out.String())
+}
@@ -0,0 +1,137 @@
+package template
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/csmith/contempt/pkg/materials"
+)
+
+// Execution holds the state of a single execution of a template, and collects
+// the materials that are used by it.
+//
+// When a template is executed, the engine invokes each registered
+// FunctionSource with a new Execution as its BomWriter. Sources that need to
+// know more about the execution they are part of can retrieve it using
+// ExecutionOf.
+type Execution struct {
+	ctx     context.Context
+	callCtx context.Context
+	callBom materials.BOM
+	path    string
+	logger  *slog.Logger
+	mutex   sync.Mutex
+	bom     materials.BOM
+}
+
+func newExecution(ctx context.Context, logger *slog.Logger, path string) *Execution {
+	return &Execution{
+		ctx:    ctx,
+		path:   path,
+		logger: logger.With("path", path),
+		bom:    make(materials.BOM),
+	}
+}
+
+// ExecutionOf returns the Execution that the given BomWriter belongs to, or
+// nil if it was not created by an Engine executing a template (for example,
+// during a dry run).
+func ExecutionOf(writer BomWriter) *Execution {
+	if e, ok := writer.(*Execution); ok {
+		return e
+	}
+	return nil
+}
+
+// ContextOf returns the context that functions using the given BomWriter
+// should use for any requests they make. If the writer does not belong to an
+// Execution, context.Background() is returned.
+func ContextOf(writer BomWriter) context.Context {
+	if e := ExecutionOf(writer); e != nil {
+		return e.Context()
+	}
+	return context.Background()
+}
+
+// Context returns the context of the function call currently being made by
+// the template, or of the execution as a whole if no call is in progress.
+func (e *Execution) Context() context.Context {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.callCtx != nil {
+		return e.callCtx
+	}
+	return e.ctx
+}
+
+// startCall creates a new context for a function call, which is returned by
+// Context until the returned finish function is called. If timeout is zero,
+// the call is only limited by the execution's own context. Materials written
+// during the call are available from callMaterials until it finishes.
+func (e *Execution) startCall(timeout time.Duration) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(e.ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(e.ctx)
+	}
+
+	e.mutex.Lock()
+	e.callCtx = ctx
+	e.callBom = make(materials.BOM)
+	e.mutex.Unlock()
+
+	return ctx, func() {
+		cancel()
+		e.mutex.Lock()
+		e.callCtx = nil
+		e.callBom = nil
+		e.mutex.Unlock()
+	}
+}
+
+// callMaterials returns a copy of the materials written during the current
+// function call.
+func (e *Execution) callMaterials() materials.BOM {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return maps.Clone(e.callBom)
+}
+
+// Path returns the path of the template being executed.
+func (e *Execution) Path() string {
+	return e.path
+}
+
+// Logger returns a logger that includes details of the execution.
+func (e *Execution) Logger() *slog.Logger {
+	return e.logger
+}
+
+// Write records that the template uses the given version of a material.
+// It is safe for concurrent use.
+func (e *Execution) Write(material, version string) {
+	e.logger.Debug("gathered material", "material", material, "version", version)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.bom[material] = version
+	if e.callBom != nil {
+		e.callBom[material] = version
+	}
+}
+
+// Materials returns a copy of the materials gathered so far.
+func (e *Execution) Materials() materials.BOM {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return maps.Clone(e.bom)
+}
+
+// discardBomWriter ignores all materials written to it.
+type discardBomWriter struct{}
+
+func (discardBomWriter) Write(string, string) {}
@@ -0,0 +1,151 @@
+package template
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/csmith/contempt/pkg/materials"
+)
+
+// lockfileVersion is the version of the lockfile format written by Lockfile.Write.
+const lockfileVersion = 1
+
+// Lockfile records the results of template function calls, so that templates
+// can later be rendered reproducibly without access to the network. It is safe
+// for concurrent use.
+type Lockfile struct {
+	mutex sync.Mutex
+	calls map[string]*LockedCall
+}
+
+// LockedCall is the recorded result of a single call to a template function.
+type LockedCall struct {
+	// Function is the name of the template function that was called.
+	Function string `json:"function"`
+	// Args are the arguments the function was called with.
+	Args json.RawMessage `json:"args"`
+	// Result is the value the function returned.
+	Result json.RawMessage `json:"result"`
+	// Materials are the materials the function wrote to the BOM during the call.
+	Materials materials.BOM `json:"materials,omitempty"`
+}
+
+type lockfileContent struct {
+	Version int           `json:"version"`
+	Calls   []*LockedCall `json:"calls"`
+}
+
+// NewLockfile creates a new, empty, lockfile.
+func NewLockfile() *Lockfile {
+	return &Lockfile{
+		calls: make(map[string]*LockedCall),
+	}
+}
+
+// ReadLockfile reads a lockfile previously written by Lockfile.Write.
+func ReadLockfile(path string) (*Lockfile, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var content lockfileContent
+	if err := json.Unmarshal(bs, &content); err != nil {
+		return nil, fmt.Errorf("invalid lockfile %s: %v", path, err)
+	}
+
+	if content.Version != lockfileVersion {
+		return nil, fmt.Errorf("unsupported lockfile version %d in %s", content.Version, path)
+	}
+
+	l := NewLockfile()
+	for i := range content.Calls {
+		// Arguments are indented when written, so must be compacted to match the keys used when looking up calls.
+		args := &bytes.Buffer{}
+		if err := json.Compact(args, content.Calls[i].Args); err != nil {
+			return nil, fmt.Errorf("invalid arguments for %s in lockfile %s: %v", content.Calls[i].Function, path, err)
+		}
+		content.Calls[i].Args = args.Bytes()
+		l.calls[lockKey(content.Calls[i].Function, content.Calls[i].Args)] = content.Calls[i]
+	}
+	return l, nil
+}
+
+// Write writes all recorded calls to the given path, sorted so that the
+// output is stable.
+func (l *Lockfile) Write(path string) error {
+	l.mutex.Lock()
+	content := lockfileContent{Version: lockfileVersion}
+	for i := range l.calls {
+		content.Calls = append(content.Calls, l.calls[i])
+	}
+	l.mutex.Unlock()
+
+	sort.Slice(content.Calls, func(i, j int) bool {
+		if content.Calls[i].Function != content.Calls[j].Function {
+			return content.Calls[i].Function < content.Calls[j].Function
+		}
+		return string(content.Calls[i].Args) < string(content.Calls[j].Args)
+	})
+
+	bs, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(bs, '\n'), os.FileMode(0644))
+}
+
+// Len returns the number of calls recorded in the lockfile.
+func (l *Lockfile) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.calls)
+}
+
+// record stores the result of a call, replacing any earlier result for the
+// same function and arguments.
+func (l *Lockfile) record(function string, args []any, result any, bom materials.BOM) error {
+	argsJSON, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("unable to record arguments in lockfile: %v", err)
+	}
+
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("unable to record result in lockfile: %v", err)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.calls[lockKey(function, argsJSON)] = &LockedCall{
+		Function:  function,
+		Args:      argsJSON,
+		Result:    resultJSON,
+		Materials: bom,
+	}
+	return nil
+}
+
+// lookup returns the recorded result of a call, if there is one.
+func (l *Lockfile) lookup(function string, args []any) (*LockedCall, error) {
+	argsJSON, err := json.Marshal(args)
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up arguments in lockfile: %v", err)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	call, ok := l.calls[lockKey(function, argsJSON)]
+	if !ok {
+		return nil, fmt.Errorf("%s(%s) is not in the lockfile", function, formatArgs(args))
+	}
+	return call, nil
+}
+
+func lockKey(function string, args json.RawMessage) string {
+	return fmt.Sprintf("%s%s", function, args)
+}
@@ -14,7 +14,9 @@ func AlpinePackagesSource(mirror string) template.FunctionSource {
 	return func(writer template.BomWriter) tt.FuncMap {
 		return tt.FuncMap{
 			"alpine_packages": func(packages ...string) (map[string]string, error) {
-				res, err := latestAlpinePackages(mirror, packages...)
+				res, err := cached("alpine_packages", fmt.Sprintf("%s|%s", mirror, strings.Join(packages, ",")), func() (map[string]string, error) {
+					return latestAlpinePackages(template.ContextOf(writer), mirror, packages...)
+				})
 				if err != nil {
 					return nil, err
 				}
@@ -29,8 +31,8 @@ func AlpinePackagesSource(mirror string) template.FunctionSource {
 
 // latestAlpinePackages returns a map of packages to their latest version. The result will include all the provided
 // package names, plus all of their direct and transitive dependencies.
-func latestAlpinePackages(mirror string, names ...string) (map[string]string, error) {
-	packages, err := apkPackageInfos(mirror)
+func latestAlpinePackages(ctx context.Context, mirror string, names ...string) (map[string]string, error) {
+	packages, err := apkPackageInfos(ctx, mirror)
 	if err != nil {
 		return nil, err
 	}
@@ -66,33 +68,24 @@ func latestAlpinePackages(mirror string, names ...string) (map[string]string, er
 	return res, nil
 }
 
-var apkPackageCache map[string]*latest.AlpinePackageInfo
+var apkPackageCache lookupGroup[map[string]*latest.AlpinePackageInfo]
 
-// apkPackageInfos returns a map of all apk packages and their latest info.
-func apkPackageInfos(mirror string) (map[string]*latest.AlpinePackageInfo, error) {
-	if apkPackageCache != nil {
-		return apkPackageCache, nil
-	}
-
-	apkPackageCache = make(map[string]*latest.AlpinePackageInfo)
-	for _, repo := range []string{"community", "main"} {
-		err := func() error {
-			info, err := latest.AlpinePackages(context.Background(), &latest.AlpinePackagesOptions{
+// apkPackageInfos returns a map of all apk packages and their latest info. The returned map must not be modified.
+func apkPackageInfos(ctx context.Context, mirror string) (map[string]*latest.AlpinePackageInfo, error) {
+	return apkPackageCache.get(ctx, mirror, func(ctx context.Context) (map[string]*latest.AlpinePackageInfo, error) {
+		packages := make(map[string]*latest.AlpinePackageInfo)
+		for _, repo := range []string{"community", "main"} {
+			info, err := latest.AlpinePackages(ctx, &latest.AlpinePackagesOptions{
 				Mirror:     mirror,
 				Repository: repo,
 			})
 			if err != nil {
-				return err
+				return nil, err
 			}
 			for k := range info {
-				apkPackageCache[k] = info[k]
+				packages[k] = info[k]
 			}
-			return nil
-		}()
-		if err != nil {
-			return nil, err
 		}
-	}
-
-	return apkPackageCache, nil
+		return packages, nil
+	})
 }
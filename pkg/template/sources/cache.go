@@ -0,0 +1,192 @@
+package sources
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/csmith/contempt/pkg/cache"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	cacheDir  = flag.String("cache-dir", "", "Directory to cache the results of lookups in between runs (disabled if empty)")
+	refresh   = flag.Bool("refresh", false, "Whether to ignore previously cached lookups and look everything up again")
+	cacheTTLs = flag.String("cache-ttl", "", "Comma-separated list of how long cached lookups remain valid for each source, e.g. \"image=30m,git=2h\"")
+)
+
+// defaultCacheTTLs are how long cached lookups remain valid for each source, unless overridden by the -cache-ttl flag.
+var defaultCacheTTLs = map[string]time.Duration{
+	"alpine_packages":   time.Hour,
+	"crate":             time.Hour,
+	"debian_packages":   time.Hour,
+	"git":               time.Hour,
+	"gomod":             time.Hour,
+	"image":             time.Hour,
+	"json_url_content":  time.Hour,
+	"npm":               time.Hour,
+	"pypi":              time.Hour,
+	"regex_url_content": time.Hour,
+	"release":           6 * time.Hour,
+	"release_asset":     time.Hour,
+	"rpm_packages":      time.Hour,
+	"ubuntu_packages":   time.Hour,
+	"url_digest":        30 * 24 * time.Hour,
+	"yaml_url_content":  time.Hour,
+}
+
+var (
+	lookupCacheOnce sync.Once
+	lookupCache     *cache.Cache
+	lookupCacheTTLs map[string]time.Duration
+	lookupCacheErr  error
+)
+
+// configuredCache returns the cache configured by the -cache-dir, -refresh and -cache-ttl flags, along with the TTL
+// for each source. If caching is disabled, the returned cache is nil.
+func configuredCache() (*cache.Cache, map[string]time.Duration, error) {
+	lookupCacheOnce.Do(func() {
+		lookupCacheTTLs, lookupCacheErr = parseCacheTTLs(*cacheTTLs)
+		if lookupCacheErr != nil {
+			return
+		}
+
+		if *cacheDir != "" {
+			lookupCache = cache.New(*cacheDir, *refresh)
+		}
+	})
+	return lookupCache, lookupCacheTTLs, lookupCacheErr
+}
+
+// parseCacheTTLs returns the TTL for each source, given a comma-separated list of overrides to the defaults in the
+// form "source=duration".
+func parseCacheTTLs(overrides string) (map[string]time.Duration, error) {
+	res := make(map[string]time.Duration)
+	for k, v := range defaultCacheTTLs {
+		res[k] = v
+	}
+
+	if overrides == "" {
+		return res, nil
+	}
+
+	for _, part := range strings.Split(overrides, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid cache TTL %q, expected source=duration", part)
+		}
+
+		if _, ok := defaultCacheTTLs[name]; !ok {
+			return nil, fmt.Errorf("invalid cache TTL for unknown source %q, must be one of: %s", name, strings.Join(slices.Sorted(maps.Keys(defaultCacheTTLs)), ", "))
+		}
+
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache TTL for %s: %v", name, err)
+		}
+		res[name] = ttl
+	}
+	return res, nil
+}
+
+// cached returns the result of the given lookup, using the on-disk cache if it is enabled and contains a fresh
+// result for the key in the given source's namespace. Errors are never cached.
+func cached[T any](source, key string, lookup func() (T, error)) (T, error) {
+	var res T
+
+	c, ttls, err := configuredCache()
+	if err != nil {
+		return res, err
+	}
+
+	if c.Get(source, key, ttls[source], &res) {
+		return res, nil
+	}
+
+	return refreshCached(source, key, lookup)
+}
+
+// refreshCached performs the given lookup and stores its result in the on-disk cache if it is enabled, replacing
+// any existing result for the key in the given source's namespace. Errors are never cached.
+func refreshCached[T any](source, key string, lookup func() (T, error)) (T, error) {
+	res, err := lookup()
+	if err != nil {
+		return res, err
+	}
+
+	storeCached(source, key, res)
+	return res, nil
+}
+
+// storeCached stores the value in the on-disk cache if it is enabled, replacing any existing result for the key in
+// the given source's namespace.
+func storeCached(source, key string, value any) {
+	c, _, err := configuredCache()
+	if err != nil {
+		return
+	}
+
+	if err := c.Put(source, key, value); err != nil {
+		log.Printf("Unable to cache %s lookup for %s: %v", source, key, err)
+	}
+}
+
+// sharedLookupTimeout is how long a lookup performed by a lookupGroup may take. Shared lookups are detached from the
+// context of the caller that started them, as other callers may be waiting on the result.
+const sharedLookupTimeout = 5 * time.Minute
+
+// lookupGroup remembers the results of successful lookups in memory for the lifetime of the process, and ensures that
+// only one lookup for each key is in progress at a time. It is safe for concurrent use.
+type lookupGroup[T any] struct {
+	mutex   sync.Mutex
+	results map[string]T
+	flight  singleflight.Group
+}
+
+// get returns the remembered result for the key, or performs the lookup. If a lookup for the key is already in
+// progress, get waits for it to finish instead, giving up with the context's error if the context is done first.
+//
+// The lookup is given a context that keeps the values of ctx but isn't cancelled with it, and instead times out after
+// sharedLookupTimeout, so that one caller giving up doesn't fail the lookup for everyone else waiting on it.
+func (g *lookupGroup[T]) get(ctx context.Context, key string, lookup func(ctx context.Context) (T, error)) (T, error) {
+	g.mutex.Lock()
+	res, ok := g.results[key]
+	g.mutex.Unlock()
+	if ok {
+		return res, nil
+	}
+
+	ch := g.flight.DoChan(key, func() (interface{}, error) {
+		lookupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sharedLookupTimeout)
+		defer cancel()
+
+		res, err := lookup(lookupCtx)
+		if err != nil {
+			return nil, err
+		}
+
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+		if g.results == nil {
+			g.results = make(map[string]T)
+		}
+		g.results[key] = res
+		return res, nil
+	})
+
+	select {
+	case r := <-ch:
+		if r.Err != nil {
+			return res, r.Err
+		}
+		return r.Val.(T), nil
+	case <-ctx.Done():
+		return res, ctx.Err()
+	}
+}
@@ -0,0 +1,110 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupGroup(t *testing.T) {
+	var group lookupGroup[string]
+	started := make(chan struct{})
+	release := make(chan struct{})
+	lookups := 0
+
+	slow := func(context.Context) (string, error) {
+		lookups++
+		close(started)
+		<-release
+		return "result", nil
+	}
+
+	done := make(chan string)
+	go func() {
+		res, err := group.get(t.Context(), "key", slow)
+		assert.NoError(t, err)
+		done <- res
+	}()
+	<-started
+
+	// A waiter whose context finishes gives up without waiting for the lookup.
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	_, err := group.get(ctx, "key", slow)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	close(release)
+	assert.Equal(t, "result", <-done)
+
+	// Successful results are remembered.
+	res, err := group.get(t.Context(), "key", slow)
+	require.NoError(t, err)
+	assert.Equal(t, "result", res)
+	assert.Equal(t, 1, lookups)
+
+	// Failures are not.
+	_, err = group.get(t.Context(), "other", func(context.Context) (string, error) { return "", fmt.Errorf("failed") })
+	assert.EqualError(t, err, "failed")
+	res, err = group.get(t.Context(), "other", func(context.Context) (string, error) { return "second", nil })
+	require.NoError(t, err)
+	assert.Equal(t, "second", res)
+}
+
+func TestLookupGroup_firstCallerCancelled(t *testing.T) {
+	var group lookupGroup[string]
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	lookup := func(ctx context.Context) (string, error) {
+		close(started)
+		<-release
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		return "result", nil
+	}
+
+	// The first caller starts the lookup, then gives up.
+	ctx, cancel := context.WithCancel(t.Context())
+	first := make(chan error)
+	go func() {
+		_, err := group.get(ctx, "key", lookup)
+		first <- err
+	}()
+	<-started
+
+	second := make(chan string)
+	go func() {
+		res, err := group.get(t.Context(), "key", lookup)
+		assert.NoError(t, err)
+		second <- res
+	}()
+
+	cancel()
+	assert.ErrorIs(t, <-first, context.Canceled)
+
+	// The lookup carries on for the second caller.
+	close(release)
+	assert.Equal(t, "result", <-second)
+}
+
+func TestParseCacheTTLs(t *testing.T) {
+	got, err := parseCacheTTLs("image=30m, git=2h")
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, got["image"])
+	assert.Equal(t, 2*time.Hour, got["git"])
+	assert.Equal(t, defaultCacheTTLs["release"], got["release"])
+
+	_, err = parseCacheTTLs("image")
+	assert.EqualError(t, err, `invalid cache TTL "image", expected source=duration`)
+
+	_, err = parseCacheTTLs("image=soon")
+	assert.ErrorContains(t, err, "invalid cache TTL for image")
+
+	_, err = parseCacheTTLs("images=1h")
+	assert.ErrorContains(t, err, `invalid cache TTL for unknown source "images", must be one of: alpine_packages, crate,`)
+}
@@ -0,0 +1,133 @@
+package sources
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dockerHubServer is the name docker uses to store credentials for Docker Hub.
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// dockerConfig is the subset of a docker config.json (or containers auth.json) file that describes credentials.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+	CredHelpers map[string]string `json:"credHelpers"`
+	CredsStore  string            `json:"credsStore"`
+}
+
+// registryCredentials returns the credentials to use for the given image. The -registry-user and -registry-pass flags
+// are used for images in the configured registry, otherwise credentials are read from the docker config file (see
+// [readDockerConfig]). Empty strings are returned if no credentials are available.
+func registryCredentials(ref imageRef) (string, string) {
+	if ref.Configured && (*registryUser != "" || *registryPass != "") {
+		return *registryUser, *registryPass
+	}
+
+	config, err := readDockerConfig()
+	if err != nil {
+		return "", ""
+	}
+	return config.credentials(ref.Registry)
+}
+
+// readDockerConfig reads credentials from `$DOCKER_CONFIG/config.json` (or `~/.docker/config.json`) if it exists, else
+// from `${XDG_RUNTIME_DIR}/containers/auth.json`.
+func readDockerConfig() (dockerConfig, error) {
+	var config dockerConfig
+
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			dir = filepath.Join(home, ".docker")
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		content, err = os.ReadFile(filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), "containers", "auth.json"))
+	}
+	if err != nil {
+		return config, err
+	}
+
+	return config, json.Unmarshal(content, &config)
+}
+
+// credentials returns the username and password stored for the registry, either directly in the config or, if the
+// -registry-credential-helpers flag is set, in a credential helper.
+func (c dockerConfig) credentials(registry string) (string, string) {
+	if *registryCredentialHelpers {
+		server := registry
+		if registry == "docker.io" {
+			server = dockerHubServer
+		}
+
+		if helper := c.CredHelpers[registry]; helper != "" {
+			return credentialHelper(helper, server)
+		} else if c.CredsStore != "" {
+			if username, password := credentialHelper(c.CredsStore, server); username != "" || password != "" {
+				return username, password
+			}
+		}
+	}
+
+	for key, auth := range c.Auths {
+		if credentialServer(key) != registry {
+			continue
+		}
+
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				continue
+			}
+			username, password, _ := strings.Cut(string(decoded), ":")
+			return username, password
+		}
+		return auth.Username, auth.Password
+	}
+
+	return "", ""
+}
+
+// credentialServer normalises a key in the auths section of a docker config to a registry name, e.g.
+// "https://index.docker.io/v1/" to "docker.io".
+func credentialServer(key string) string {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	key, _, _ = strings.Cut(key, "/")
+	if key == "index.docker.io" || key == "registry-1.docker.io" {
+		return "docker.io"
+	}
+	return key
+}
+
+// credentialHelper retrieves credentials for the server from a docker credential helper, e.g.
+// "docker-credential-pass". Empty strings are returned if the helper fails.
+func credentialHelper(helper, server string) (string, string) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", ""
+	}
+
+	var res struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return "", ""
+	}
+	return res.Username, res.Secret
+}
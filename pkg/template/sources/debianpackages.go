@@ -0,0 +1,288 @@
+package sources
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	tt "text/template"
+
+	"github.com/csmith/contempt/pkg/template"
+)
+
+var (
+	debianMirror       = flag.String("debian-mirror", "https://deb.debian.org/debian/", "Base URL of the Debian mirror to use to query package info")
+	debianSuite        = flag.String("debian-suite", "stable", "Debian suite (or codename) to query package info from")
+	debianComponents   = flag.String("debian-components", "main", "Comma-separated list of Debian components to query package info from")
+	ubuntuMirror       = flag.String("ubuntu-mirror", "", "Base URL of the Ubuntu mirror to use to query package info (defaults to archive.ubuntu.com for amd64 and i386, and ports.ubuntu.com for other architectures)")
+	ubuntuSuite        = flag.String("ubuntu-suite", "noble", "Ubuntu suite (or codename) to query package info from")
+	ubuntuComponents   = flag.String("ubuntu-components", "main,universe", "Comma-separated list of Ubuntu components to query package info from")
+	debianArchitecture = flag.String("deb-architecture", "amd64", "Architecture to query Debian and Ubuntu package info for")
+)
+
+// debRepository describes where to find the Packages indices of a Debian-style repository.
+type debRepository struct {
+	Mirror       string
+	Suite        string
+	Components   []string
+	Architecture string
+}
+
+func (r debRepository) String() string {
+	return fmt.Sprintf("%s|%s|%s|%s", r.Mirror, r.Suite, strings.Join(r.Components, ","), r.Architecture)
+}
+
+func DebianPackagesSource() template.FunctionSource {
+	return debPackagesSource("debian_packages", func() debRepository {
+		return debRepository{
+			Mirror:       *debianMirror,
+			Suite:        *debianSuite,
+			Components:   strings.Split(*debianComponents, ","),
+			Architecture: *debianArchitecture,
+		}
+	})
+}
+
+func UbuntuPackagesSource() template.FunctionSource {
+	return debPackagesSource("ubuntu_packages", func() debRepository {
+		return debRepository{
+			Mirror:       ubuntuMirrorFor(*ubuntuMirror, *debianArchitecture),
+			Suite:        *ubuntuSuite,
+			Components:   strings.Split(*ubuntuComponents, ","),
+			Architecture: *debianArchitecture,
+		}
+	})
+}
+
+// ubuntuMirrorFor returns the given Ubuntu mirror, or if it's empty the official mirror for the architecture. The main
+// archive only carries amd64 and i386 packages; all other architectures are served from the ports archive.
+func ubuntuMirrorFor(mirror, architecture string) string {
+	if mirror != "" {
+		return mirror
+	}
+	if architecture == "amd64" || architecture == "i386" {
+		return "http://archive.ubuntu.com/ubuntu/"
+	}
+	return "http://ports.ubuntu.com/ubuntu-ports/"
+}
+
+// debPackagesSource creates a function with the given name that resolves packages from a Debian-style repository.
+// The repository is only configured when the function is called, so that it reflects any flags parsed after the
+// source is created.
+func debPackagesSource(name string, repository func() debRepository) template.FunctionSource {
+	return func(writer template.BomWriter) tt.FuncMap {
+		return tt.FuncMap{
+			name: func(packages ...string) (map[string]string, error) {
+				repo := repository()
+				res, err := cached(name, fmt.Sprintf("%s|%s", repo, strings.Join(packages, ",")), func() (map[string]string, error) {
+					return latestDebPackages(template.ContextOf(writer), repo, packages...)
+				})
+				if err != nil {
+					return nil, err
+				}
+				for i := range res {
+					writer.Write(fmt.Sprintf("deb:%s", i), res[i])
+				}
+				return res, nil
+			},
+		}
+	}
+}
+
+// latestDebPackages returns a map of packages to their latest version. The result will include all the provided
+// package names (or a package that provides them, if they are virtual), plus all of their direct and transitive
+// dependencies.
+func latestDebPackages(ctx context.Context, repo debRepository, names ...string) (map[string]string, error) {
+	index, err := debPackageInfos(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return index.resolve(names...)
+}
+
+var debPackageCache lookupGroup[*packageIndex]
+
+// debPackageInfos returns the index of all packages in the given repository. The returned index must not be modified.
+func debPackageInfos(ctx context.Context, repo debRepository) (*packageIndex, error) {
+	return debPackageCache.get(ctx, repo.String(), func(ctx context.Context) (*packageIndex, error) {
+		index := newPackageIndex(compareDebVersions)
+
+		for _, component := range repo.Components {
+			url := fmt.Sprintf(
+				"%s/dists/%s/%s/binary-%s/Packages.gz",
+				strings.TrimSuffix(repo.Mirror, "/"),
+				repo.Suite,
+				strings.TrimSpace(component),
+				repo.Architecture,
+			)
+			if err := downloadDebPackages(ctx, index, url); err != nil {
+				return nil, err
+			}
+		}
+
+		index.finish()
+		return index, nil
+	})
+}
+
+// downloadDebPackages retrieves the gzipped Packages index at the given URL, and adds its packages to the index.
+func downloadDebPackages(ctx context.Context, index *packageIndex, url string) error {
+	body, err := httpGet(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	reader, err := gzip.NewReader(body)
+	if err != nil {
+		return fmt.Errorf("unable to decompress package index %s: %v", url, err)
+	}
+	defer reader.Close()
+
+	if err := parseDebPackages(index, reader); err != nil {
+		return fmt.Errorf("unable to parse package index %s: %v", url, err)
+	}
+	return nil
+}
+
+// parseDebPackages reads a Packages index and adds its packages to the index.
+func parseDebPackages(index *packageIndex, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	current := &packageInfo{}
+	add := func() {
+		if current.Name != "" {
+			index.add(current)
+		}
+		current = &packageInfo{}
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			add()
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			// Continuation of a multi-line field (such as Description), which we don't care about.
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "Package":
+			current.Name = value
+		case "Version":
+			current.Version = value
+		case "Depends", "Pre-Depends":
+			current.Dependencies = append(current.Dependencies, parseDebRelations(value)...)
+		case "Provides":
+			for _, provided := range parseDebRelations(value) {
+				current.Provides = append(current.Provides, provided...)
+			}
+		}
+	}
+
+	add()
+	return scanner.Err()
+}
+
+// parseDebRelations parses a list of package relationships, such as a Depends field, into a list of alternatives
+// for each relationship. Version constraints, architecture restrictions and build profiles are discarded.
+func parseDebRelations(value string) [][]string {
+	var res [][]string
+	for _, relation := range strings.Split(value, ",") {
+		var alternatives []string
+		for _, alternative := range strings.Split(relation, "|") {
+			name := strings.TrimSpace(alternative)
+			if index := strings.IndexAny(name, " ([<"); index != -1 {
+				name = name[:index]
+			}
+			name, _, _ = strings.Cut(name, ":")
+			if name != "" {
+				alternatives = append(alternatives, name)
+			}
+		}
+		if len(alternatives) > 0 {
+			res = append(res, alternatives)
+		}
+	}
+	return res
+}
+
+// compareDebVersions compares two Debian package versions according to the rules used by dpkg, returning a
+// negative number if a is older than b, a positive number if a is newer than b, and zero if they are equal.
+func compareDebVersions(a, b string) int {
+	aEpoch, aRest := splitEpoch(a)
+	bEpoch, bRest := splitEpoch(b)
+	if c := compareNumbers(aEpoch, bEpoch); c != 0 {
+		return c
+	}
+
+	aUpstream, aRevision := splitRevision(aRest)
+	bUpstream, bRevision := splitRevision(bRest)
+	if c := compareDebPart(aUpstream, bUpstream); c != 0 {
+		return c
+	}
+	return compareDebPart(aRevision, bRevision)
+}
+
+// compareDebPart compares an upstream version or revision, alternating between non-digit and digit sections.
+func compareDebPart(a, b string) int {
+	for a != "" || b != "" {
+		var aText, bText string
+		aText, a = splitDigits(a, false)
+		bText, b = splitDigits(b, false)
+		if c := compareDebText(aText, bText); c != 0 {
+			return c
+		}
+
+		var aNumber, bNumber string
+		aNumber, a = splitDigits(a, true)
+		bNumber, b = splitDigits(b, true)
+		if c := compareNumbers(aNumber, bNumber); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareDebText compares non-digit sections of a version. Letters sort before non-letters, and a tilde sorts
+// before everything, even the end of the section.
+func compareDebText(a, b string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var aOrder, bOrder int
+		if i < len(a) {
+			aOrder = debCharOrder(a[i])
+		}
+		if i < len(b) {
+			bOrder = debCharOrder(b[i])
+		}
+		if aOrder != bOrder {
+			return aOrder - bOrder
+		}
+	}
+	return 0
+}
+
+func debCharOrder(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return int(c)
+	default:
+		return int(c) + 256
+	}
+}
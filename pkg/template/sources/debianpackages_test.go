@@ -0,0 +1,170 @@
+package sources
+
+import (
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPackagesIndex = `Package: curl
+Version: 7.88.1-10+deb12u5
+Depends: libc6 (>= 2.34), libcurl4 (= 7.88.1-10+deb12u5), zlib1g (>= 1:1.1.4)
+Description: command line tool for transferring data with URL syntax
+ curl is a command line tool for transferring data with URL syntax.
+
+Package: libc6
+Version: 2.36-9+deb12u4
+Pre-Depends: libgcc-s1
+
+Package: libgcc-s1
+Version: 12.2.0-14
+
+Package: libcurl4
+Version: 7.88.1-10+deb12u5
+Depends: libc6 (>= 2.34), libssl3 | libssl-dev, ca-certificates-bundle [amd64]
+
+Package: libssl3
+Version: 3.0.11-1~deb12u2
+
+Package: libssl3
+Version: 3.0.13-1~deb12u1
+
+Package: mozilla-certificates
+Version: 20230311
+Provides: ca-certificates-bundle (= 1)
+
+Package: zlib1g
+Version: 1:1.2.13.dfsg-1
+Depends: libc6:any (>= 2.14)
+`
+
+func TestParseDebPackages(t *testing.T) {
+	index := newPackageIndex(compareDebVersions)
+	require.NoError(t, parseDebPackages(index, strings.NewReader(testPackagesIndex)))
+	index.finish()
+
+	tests := []struct {
+		name     string
+		packages []string
+		want     map[string]string
+		wantErr  string
+	}{
+		{
+			name:     "package with no dependencies",
+			packages: []string{"libgcc-s1"},
+			want:     map[string]string{"libgcc-s1": "12.2.0-14"},
+		},
+		{
+			name:     "transitive dependencies, alternatives and virtual packages",
+			packages: []string{"curl"},
+			want: map[string]string{
+				"curl":                 "7.88.1-10+deb12u5",
+				"libc6":                "2.36-9+deb12u4",
+				"libcurl4":             "7.88.1-10+deb12u5",
+				"libgcc-s1":            "12.2.0-14",
+				"libssl3":              "3.0.13-1~deb12u1",
+				"mozilla-certificates": "20230311",
+				"zlib1g":               "1:1.2.13.dfsg-1",
+			},
+		},
+		{
+			name:     "requesting a virtual package",
+			packages: []string{"ca-certificates-bundle"},
+			want:     map[string]string{"mozilla-certificates": "20230311"},
+		},
+		{
+			name:     "missing package",
+			packages: []string{"wget"},
+			wantErr:  "package required but not found: wget",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := index.resolve(tt.packages...)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLatestDebPackages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/debian/dists/bookworm/main/binary-arm64/Packages.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		writer := gzip.NewWriter(w)
+		_, _ = writer.Write([]byte(testPackagesIndex))
+		_ = writer.Close()
+	}))
+	defer server.Close()
+
+	got, err := latestDebPackages(t.Context(), debRepository{
+		Mirror:       server.URL + "/debian/",
+		Suite:        "bookworm",
+		Components:   []string{"main"},
+		Architecture: "arm64",
+	}, "zlib1g")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"libc6":     "2.36-9+deb12u4",
+		"libgcc-s1": "12.2.0-14",
+		"zlib1g":    "1:1.2.13.dfsg-1",
+	}, got)
+
+	_, err = latestDebPackages(t.Context(), debRepository{
+		Mirror:       server.URL + "/debian/",
+		Suite:        "trixie",
+		Components:   []string{"main"},
+		Architecture: "arm64",
+	}, "zlib1g")
+	assert.ErrorContains(t, err, "404 Not Found")
+}
+
+func TestUbuntuMirrorFor(t *testing.T) {
+	assert.Equal(t, "http://archive.ubuntu.com/ubuntu/", ubuntuMirrorFor("", "amd64"))
+	assert.Equal(t, "http://archive.ubuntu.com/ubuntu/", ubuntuMirrorFor("", "i386"))
+	assert.Equal(t, "http://ports.ubuntu.com/ubuntu-ports/", ubuntuMirrorFor("", "arm64"))
+	assert.Equal(t, "https://mirror.example.com/ubuntu/", ubuntuMirrorFor("https://mirror.example.com/ubuntu/", "arm64"))
+}
+
+func TestCompareDebVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.10", "1.9", 1},
+		{"1:1.0", "2.0", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0-1", "1.0-2", -1},
+		{"1.0a", "1.0+", -1},
+		{"3.0.11-1~deb12u2", "3.0.13-1~deb12u1", -1},
+		{"7.88.1-10+deb12u5", "7.88.1-10", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"_"+tt.b, func(t *testing.T) {
+			got := compareDebVersions(tt.a, tt.b)
+			switch {
+			case tt.want < 0:
+				assert.Negative(t, got)
+			case tt.want > 0:
+				assert.Positive(t, got)
+			default:
+				assert.Zero(t, got)
+			}
+		})
+	}
+}
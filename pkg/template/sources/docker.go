@@ -1,45 +1,113 @@
 package sources
 
 import (
-	"context"
 	"flag"
 	"fmt"
 	"strings"
+	"sync"
 	tt "text/template"
 
 	"github.com/csmith/contempt/pkg/template"
-	"github.com/csmith/latest/v3"
 )
 
 var (
 	registryUser = flag.String("registry-user", "", "Username to use when querying the container registry")
 	registryPass = flag.String("registry-pass", "", "Password to use when querying the container registry")
+
+	registryCredentialHelpers = flag.Bool("registry-credential-helpers", false, "Whether to run the docker credential helpers named in the docker config file to find registry credentials")
+)
+
+var (
+	pushedImagesMutex sync.Mutex
+	pushedImages      = make(map[string]string)
 )
 
+// RecordPushedImage records that the image with the given name (e.g. "reg.c5h.io/alpine") was pushed with the given
+// digest. Subsequent calls to the image function for its latest tag return that digest instead of looking it up,
+// and the cached digest is replaced so later runs see it too. Other lookups for the image bypass the cache, and
+// replace their cached results with fresh ones.
+func RecordPushedImage(name, digest string) {
+	pushedImagesMutex.Lock()
+	pushedImages[name] = digest
+	pushedImagesMutex.Unlock()
+
+	storeCached("image", imageCacheKey(parseImageRef("", name)), digest)
+}
+
+// pushedImage returns the digest the image was pushed with, if RecordPushedImage has been called for it.
+func pushedImage(name string) (string, bool) {
+	pushedImagesMutex.Lock()
+	defer pushedImagesMutex.Unlock()
+	digest, ok := pushedImages[name]
+	return digest, ok
+}
+
+// imageCacheKey returns the key that the digest of the given image is cached under. It uses the fully-qualified name,
+// so that pushes can replace the entry regardless of how templates refer to the image.
+func imageCacheKey(ref imageRef) string {
+	return fmt.Sprintf("%s:%s|%s", ref.Name(), ref.Reference, *registryUser)
+}
+
+// cachedImage performs an image lookup, using the cache unless the image has been pushed by this process, in which
+// case the cached result is replaced.
+func cachedImage[T any](ref imageRef, key string, lookup func() (T, error)) (T, error) {
+	if _, ok := pushedImage(ref.Name()); ok {
+		return refreshCached("image", key, lookup)
+	}
+	return cached("image", key, lookup)
+}
+
 func ImageSource(registry string) template.FunctionSource {
 	return func(writer template.BomWriter) tt.FuncMap {
 		return tt.FuncMap{
 			"registry": func() string { return registry },
 			"image": func(ref string) (string, error) {
-				digest, err := latest.ImageDigest(context.Background(), ref, &latest.ImageOptions{
-					Registry: registry,
-					Username: *registryUser,
-					Password: *registryPass,
+				parsed := parseImageRef(registry, ref)
+				digest, err := cachedImage(parsed, imageCacheKey(parsed), func() (string, error) {
+					if digest, ok := pushedImage(parsed.Name()); ok && parsed.Reference == "latest" {
+						return digest, nil
+					}
+					return imageDigest(template.ContextOf(writer), parsed)
+				})
+
+				if err != nil {
+					return "", err
+				}
+
+				writer.Write(fmt.Sprintf("image:%s", ref), strings.TrimPrefix(digest, "sha256:"))
+				return fmt.Sprintf("%s@%s", qualifiedImage(registry, ref), digest), nil
+			},
+			"image_tag": func(ref, constraint string, suffix ...string) (string, error) {
+				if len(suffix) > 1 {
+					return "", fmt.Errorf("image_tag takes at most one suffix")
+				}
+
+				parsed := parseImageRef(registry, ref)
+				tag, err := cachedImage(parsed, fmt.Sprintf("%s|%s|%s|%s|%s", registry, ref, constraint, strings.Join(suffix, ""), *registryUser), func() (imageTag, error) {
+					return latestImageTag(template.ContextOf(writer), parsed, constraint, strings.Join(suffix, ""))
 				})
 
 				if err != nil {
 					return "", err
 				}
 
-				var image string
-				if index := strings.IndexByte(ref, '.'); index != -1 && index < strings.IndexByte(ref, '/') {
-					image = ref
-				} else {
-					image = fmt.Sprintf("%s/%s", registry, ref)
+				writer.Write(fmt.Sprintf("imagetag:%s@%s%s", ref, constraint, strings.Join(suffix, "")), tag.Tag)
+				writer.Write(fmt.Sprintf("image:%s:%s", ref, tag.Tag), strings.TrimPrefix(tag.Digest, "sha256:"))
+				return fmt.Sprintf("%s:%s@%s", qualifiedImage(registry, ref), tag.Tag, tag.Digest), nil
+			},
+			"image_for": func(ref, platform string) (string, error) {
+				parsed := parseImageRef(registry, ref)
+				digests, err := cachedImage(parsed, fmt.Sprintf("%s|%s|%s|%s", registry, ref, platform, *registryUser), func() (imagePlatformDigests, error) {
+					return resolveImagePlatform(template.ContextOf(writer), parsed, platform)
+				})
+
+				if err != nil {
+					return "", err
 				}
 
-				writer.Write(fmt.Sprintf("image:%s", ref), strings.TrimPrefix(digest, "sha256:"))
-				return fmt.Sprintf("%s@%s", image, digest), nil
+				writer.Write(fmt.Sprintf("image:%s", ref), strings.TrimPrefix(digests.Index, "sha256:"))
+				writer.Write(fmt.Sprintf("image:%s@%s", ref, platform), strings.TrimPrefix(digests.Platform, "sha256:"))
+				return fmt.Sprintf("%s@%s", qualifiedImage(registry, ref), digests.Platform), nil
 			},
 		}
 	}
@@ -0,0 +1,183 @@
+package sources
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useTestCache configures lookups to be cached in a temporary directory, and forgets any previously pushed images,
+// for the duration of the test.
+func useTestCache(t *testing.T) {
+	originalDir := *cacheDir
+	reset := func() {
+		lookupCacheOnce = sync.Once{}
+		lookupCache, lookupCacheTTLs, lookupCacheErr = nil, nil, nil
+		pushedImagesMutex.Lock()
+		pushedImages = make(map[string]string)
+		pushedImagesMutex.Unlock()
+	}
+
+	*cacheDir = t.TempDir()
+	reset()
+	t.Cleanup(func() {
+		*cacheDir = originalDir
+		reset()
+	})
+}
+
+// forgetPushedImages simulates a new run, which starts without any record of images pushed by earlier runs but
+// shares their cache.
+func forgetPushedImages() {
+	pushedImagesMutex.Lock()
+	pushedImages = make(map[string]string)
+	pushedImagesMutex.Unlock()
+}
+
+// testRegistry serves a "base" image with a single "1.0.0" tag, which "latest" also points to. Both resolve to the
+// current digest, which can be changed to simulate new pushes.
+type testRegistry struct {
+	mutex     sync.Mutex
+	digest    string
+	manifests int
+}
+
+func (r *testRegistry) set(digest string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.digest = digest
+}
+
+func (r *testRegistry) manifestRequests() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.manifests
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	switch req.URL.Path {
+	case "/v2/base/tags/list":
+		_, _ = w.Write([]byte(`{"name": "base", "tags": ["1.0.0", "latest"]}`))
+	case "/v2/base/manifests/latest", "/v2/base/manifests/1.0.0":
+		r.manifests++
+		w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+		w.Header().Set("Docker-Content-Digest", r.digest)
+		_, _ = fmt.Fprintf(w, `{"mediaType": "application/vnd.oci.image.index.v1+json", "manifests": [
+			{"digest": "%s-amd64", "platform": {"os": "linux", "architecture": "amd64"}}
+		]}`, r.digest)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func TestImageSource_pushedImages(t *testing.T) {
+	useTestCache(t)
+
+	backend := &testRegistry{digest: "sha256:old"}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+	lookup := func() (image, imageLatest, imageFor, imageTag string) {
+		funcs := ImageSource(registry)(bomWriter{})
+
+		var err error
+		image, err = funcs["image"].(func(string) (string, error))("base")
+		require.NoError(t, err)
+		imageLatest, err = funcs["image"].(func(string) (string, error))(registry + "/base:latest")
+		require.NoError(t, err)
+		imageFor, err = funcs["image_for"].(func(string, string) (string, error))("base", "linux/amd64")
+		require.NoError(t, err)
+		imageTag, err = funcs["image_tag"].(func(string, string, ...string) (string, error))("base", "^1")
+		require.NoError(t, err)
+		return
+	}
+	assertLookups := func(digest string) {
+		image, imageLatest, imageFor, imageTag := lookup()
+		assert.Equal(t, registry+"/base@"+digest, image)
+		assert.Equal(t, registry+"/base:latest@"+digest, imageLatest)
+		assert.Equal(t, registry+"/base@"+digest+"-amd64", imageFor)
+		assert.Equal(t, registry+"/base:1.0.0@"+digest, imageTag)
+	}
+
+	// The first run caches everything.
+	assertLookups("sha256:old")
+
+	// Another run sees the cached digests, even though the image has changed since.
+	forgetPushedImages()
+	backend.set("sha256:other")
+	assertLookups("sha256:old")
+
+	// Once this run pushes the image, every lookup sees the pushed digest. The image function doesn't need to ask
+	// the registry for the latest tag.
+	backend.set("sha256:pushed")
+	RecordPushedImage(registry+"/base", "sha256:pushed")
+	requests := backend.manifestRequests()
+	assertLookups("sha256:pushed")
+	assert.Equal(t, requests+2, backend.manifestRequests(), "only image_for and image_tag should fetch manifests")
+
+	var cachedDigest string
+	c, ttls, err := configuredCache()
+	require.NoError(t, err)
+	require.True(t, c.Get("image", imageCacheKey(parseImageRef(registry, "base")), ttls["image"], &cachedDigest))
+	assert.Equal(t, "sha256:pushed", cachedDigest)
+
+	// The next run sees the pushed digests in the cache.
+	forgetPushedImages()
+	backend.set("sha256:later")
+	assertLookups("sha256:pushed")
+}
+
+func TestImageSource_pushedImageWithoutEarlierLookup(t *testing.T) {
+	useTestCache(t)
+
+	RecordPushedImage("reg.example.com/base", "sha256:pushed")
+
+	funcs := ImageSource("reg.example.com")(bomWriter{})
+	for _, ref := range []string{"base", "base:latest", "reg.example.com/base", "reg.example.com/base:latest"} {
+		image, err := funcs["image"].(func(string) (string, error))(ref)
+		require.NoError(t, err)
+		assert.Equal(t, qualifiedImage("reg.example.com", ref)+"@sha256:pushed", image)
+	}
+
+	var cachedDigest string
+	c, _, err := configuredCache()
+	require.NoError(t, err)
+	require.True(t, c.Get("image", imageCacheKey(parseImageRef("", "reg.example.com/base")), time.Hour, &cachedDigest))
+	assert.Equal(t, "sha256:pushed", cachedDigest)
+}
+
+func TestImageCacheKey(t *testing.T) {
+	tests := []struct {
+		pushed   string
+		registry string
+		ref      string
+	}{
+		{"reg.example.com/base", "reg.example.com", "base"},
+		{"reg.example.com/base", "reg.example.com", "base:latest"},
+		{"reg.example.com/base", "reg.example.com", "reg.example.com/base"},
+		{"reg.example.com/base", "reg.example.com", "reg.example.com/base:latest"},
+		{"reg.example.com/base", "other.example.com", "reg.example.com/base:latest"},
+		{"localhost:5000/tools/go", "localhost:5000", "tools/go"},
+		{"docker.io/alpine", "reg.example.com", "docker.io/library/alpine:latest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.registry+" "+tt.ref, func(t *testing.T) {
+			assert.Equal(t, imageCacheKey(parseImageRef("", tt.pushed)), imageCacheKey(parseImageRef(tt.registry, tt.ref)))
+		})
+	}
+
+	assert.NotEqual(t, imageCacheKey(parseImageRef("", "reg.example.com/base")), imageCacheKey(parseImageRef("reg.example.com", "base:1.0")))
+	assert.NotEqual(t, imageCacheKey(parseImageRef("", "reg.example.com/base")), imageCacheKey(parseImageRef("other.example.com", "base")))
+}
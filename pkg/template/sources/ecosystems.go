@@ -0,0 +1,408 @@
+package sources
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	tt "text/template"
+	"unicode"
+
+	"github.com/csmith/contempt/pkg/template"
+)
+
+var (
+	pypiURL   = flag.String("pypi-url", "https://pypi.org/", "Base URL of the Python Package Index (or a mirror) to query package versions from")
+	npmURL    = flag.String("npm-url", "https://registry.npmjs.org/", "Base URL of the npm registry (or a mirror) to query package versions from")
+	cratesURL = flag.String("crates-url", "https://crates.io/", "Base URL of crates.io (or a mirror) to query crate versions from")
+	goproxy   = flag.String("goproxy-url", "https://proxy.golang.org/", "Base URL of the Go module proxy to query module versions from")
+)
+
+// ecosystem describes how to look up package versions and checksums from a language's package registry.
+type ecosystem struct {
+	// name is used as the prefix of template functions, materials, and the cache namespace.
+	name string
+	// baseURL is the configured base URL of the registry, which is included in cache keys.
+	baseURL *string
+	// versions returns all published (and not yanked) versions of a package.
+	versions func(ctx context.Context, pkg string) ([]string, error)
+	// latest returns the highest of the given versions, as latestSemver does. If nil, latestSemver is used.
+	latest func(versions []string, preRelease bool) (string, error)
+	// sha256 returns the hex-encoded SHA-256 checksum of the artifact for the given version of a package.
+	sha256 func(ctx context.Context, pkg, version string) (string, error)
+}
+
+func PyPISource() template.FunctionSource {
+	return ecosystemSource(ecosystem{name: "pypi", baseURL: pypiURL, versions: pypiVersions, latest: latestPypiVersion, sha256: pypiSha256})
+}
+
+func NpmSource() template.FunctionSource {
+	return ecosystemSource(ecosystem{name: "npm", baseURL: npmURL, versions: npmVersions, sha256: npmSha256})
+}
+
+func CrateSource() template.FunctionSource {
+	return ecosystemSource(ecosystem{name: "crate", baseURL: cratesURL, versions: crateVersions, sha256: crateSha256})
+}
+
+func GoModSource() template.FunctionSource {
+	return ecosystemSource(ecosystem{name: "gomod", baseURL: goproxy, versions: goModVersions, sha256: goModSha256})
+}
+
+// ecosystemSource creates the <name>_version, unreleased_<name>_version and <name>_sha256 functions for an
+// ecosystem.
+func ecosystemSource(e ecosystem) template.FunctionSource {
+	latest := e.latest
+	if latest == nil {
+		latest = latestSemver
+	}
+
+	return func(writer template.BomWriter) tt.FuncMap {
+		latestVersion := func(pkg string, preRelease bool) (string, error) {
+			v, err := cached(e.name, fmt.Sprintf("%s|%s|%t", *e.baseURL, pkg, preRelease), func() (string, error) {
+				versions, err := e.versions(template.ContextOf(writer), pkg)
+				if err != nil {
+					return "", err
+				}
+				return latest(versions, preRelease)
+			})
+			if err != nil {
+				return "", fmt.Errorf("unable to find latest version of %s package %s: %v", e.name, pkg, err)
+			}
+			writer.Write(fmt.Sprintf("%s:%s", e.name, pkg), v)
+			return v, nil
+		}
+
+		return tt.FuncMap{
+			fmt.Sprintf("%s_version", e.name): func(pkg string) (string, error) {
+				return latestVersion(pkg, false)
+			},
+
+			fmt.Sprintf("unreleased_%s_version", e.name): func(pkg string) (string, error) {
+				return latestVersion(pkg, true)
+			},
+
+			fmt.Sprintf("%s_sha256", e.name): func(pkg, version string) (string, error) {
+				checksum, err := cached(e.name, fmt.Sprintf("%s|%s|%s|sha256", *e.baseURL, pkg, version), func() (string, error) {
+					return e.sha256(template.ContextOf(writer), pkg, version)
+				})
+				if err != nil {
+					return "", fmt.Errorf("unable to find checksum of %s package %s %s: %v", e.name, pkg, version, err)
+				}
+				writer.Write(fmt.Sprintf("%s:%s", e.name, pkg), version)
+				return checksum, nil
+			},
+		}
+	}
+}
+
+// registryURL joins the given path elements onto a registry's base URL, escaping each of them.
+func registryURL(base string, elements ...string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	return u.JoinPath(elements...).String(), nil
+}
+
+// getJSON retrieves the given URL and decodes its JSON body into target.
+func getJSON(ctx context.Context, url string, target any, headers ...header) error {
+	body, err := httpGet(ctx, url, headers...)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return json.NewDecoder(body).Decode(target)
+}
+
+// downloadSha256 downloads the given URL, and returns the hex-encoded SHA-256 checksum of its contents.
+func downloadSha256(ctx context.Context, url string, headers ...header) (string, error) {
+	body, err := httpGet(ctx, url, headers...)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type pypiFile struct {
+	Filename    string `json:"filename"`
+	PackageType string `json:"packagetype"`
+	Yanked      bool   `json:"yanked"`
+	Digests     struct {
+		Sha256 string `json:"sha256"`
+	} `json:"digests"`
+}
+
+func pypiVersions(ctx context.Context, pkg string) ([]string, error) {
+	u, err := registryURL(*pypiURL, "pypi", pkg, "json")
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Releases map[string][]pypiFile `json:"releases"`
+	}
+	if err := getJSON(ctx, u, &res); err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for v, files := range res.Releases {
+		for i := range files {
+			if !files[i].Yanked {
+				versions = append(versions, v)
+				break
+			}
+		}
+	}
+	return versions, nil
+}
+
+// pep440Version matches the PEP 440 versions that latestPypiVersion normalises: a release, optionally followed by a
+// pre-release, post-release and development release segment.
+var pep440Version = regexp.MustCompile(`^(\d+(?:\.\d+)*)((?:a|b|rc)\d+)?(?:\.post(\d+))?(?:\.dev(\d+))?$`)
+
+// normalisePep440 rewrites PEP 440 post-releases and development releases, which go-version can't parse, so that
+// they compare correctly: "2.0.post1" becomes "2.0.0.1" (after 2.0 but before 2.0.1), and "2.0.dev1" becomes the
+// pre-release "2.0-dev1". Other versions are returned unchanged. It returns false for combinations of segments that
+// can't be represented, such as development releases of pre-releases ("2.0rc1.dev1").
+func normalisePep440(v string) (string, bool) {
+	m := pep440Version.FindStringSubmatch(v)
+	if m == nil {
+		return v, true
+	}
+
+	release, pre, post, dev := m[1], m[2], m[3], m[4]
+	switch {
+	case post == "" && dev == "":
+		return v, true
+	case post != "" && pre == "" && dev == "":
+		segments := strings.Split(release, ".")
+		for len(segments) < 3 {
+			segments = append(segments, "0")
+		}
+		return fmt.Sprintf("%s.%s", strings.Join(segments, "."), post), true
+	case dev != "" && pre == "" && post == "":
+		return fmt.Sprintf("%s-dev%s", release, dev), true
+	default:
+		return v, false
+	}
+}
+
+// latestPypiVersion returns the highest of the given PEP 440 versions, normalising post-releases and development
+// releases (see normalisePep440) before comparing them. Development releases are treated as pre-releases, and
+// versions that can't be normalised are ignored.
+func latestPypiVersion(versions []string, preRelease bool) (string, error) {
+	originals := make(map[string]string)
+	var normalised []string
+	for _, v := range versions {
+		if n, ok := normalisePep440(v); ok {
+			originals[n] = v
+			normalised = append(normalised, n)
+		}
+	}
+
+	v, err := latestSemver(normalised, preRelease)
+	if err != nil {
+		return "", err
+	}
+	return originals[v], nil
+}
+
+// pypiSha256 returns the checksum of the source distribution of the given version, or if there isn't one, of its
+// pure-Python wheel.
+func pypiSha256(ctx context.Context, pkg, version string) (string, error) {
+	u, err := registryURL(*pypiURL, "pypi", pkg, version, "json")
+	if err != nil {
+		return "", err
+	}
+
+	var res struct {
+		URLs []pypiFile `json:"urls"`
+	}
+	if err := getJSON(ctx, u, &res); err != nil {
+		return "", err
+	}
+
+	for _, preferred := range []func(pypiFile) bool{
+		func(f pypiFile) bool { return f.PackageType == "sdist" },
+		func(f pypiFile) bool {
+			return f.PackageType == "bdist_wheel" && strings.HasSuffix(f.Filename, "-none-any.whl")
+		},
+	} {
+		for i := range res.URLs {
+			if !res.URLs[i].Yanked && preferred(res.URLs[i]) {
+				return res.URLs[i].Digests.Sha256, nil
+			}
+		}
+	}
+	return "", errors.New("no source distribution or pure-Python wheel found")
+}
+
+type npmPackage struct {
+	Versions map[string]struct {
+		Dist struct {
+			Tarball string `json:"tarball"`
+		} `json:"dist"`
+	} `json:"versions"`
+}
+
+func npmPackageInfo(ctx context.Context, pkg string) (*npmPackage, error) {
+	u, err := registryURL(*npmURL, pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &npmPackage{}
+	return res, getJSON(ctx, u, res)
+}
+
+func npmVersions(ctx context.Context, pkg string) ([]string, error) {
+	info, err := npmPackageInfo(ctx, pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for v := range info.Versions {
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// npmSha256 returns the checksum of the given version's tarball. The registry only publishes SHA-1 and SHA-512
+// hashes, so the tarball is downloaded and hashed.
+func npmSha256(ctx context.Context, pkg, version string) (string, error) {
+	info, err := npmPackageInfo(ctx, pkg)
+	if err != nil {
+		return "", err
+	}
+
+	v, ok := info.Versions[version]
+	if !ok || v.Dist.Tarball == "" {
+		return "", errors.New("version not found")
+	}
+	return downloadSha256(ctx, v.Dist.Tarball)
+}
+
+type crateVersion struct {
+	Num      string `json:"num"`
+	Checksum string `json:"checksum"`
+	Yanked   bool   `json:"yanked"`
+}
+
+func crateVersionInfo(ctx context.Context, pkg string) ([]crateVersion, error) {
+	u, err := registryURL(*cratesURL, "api", "v1", "crates", pkg, "versions")
+	if err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		Versions []crateVersion `json:"versions"`
+	}
+	if err := getJSON(ctx, u, &body); err != nil {
+		return nil, err
+	}
+	return body.Versions, nil
+}
+
+func crateVersions(ctx context.Context, pkg string) ([]string, error) {
+	info, err := crateVersionInfo(ctx, pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for i := range info {
+		if !info[i].Yanked {
+			versions = append(versions, info[i].Num)
+		}
+	}
+	return versions, nil
+}
+
+func crateSha256(ctx context.Context, pkg, version string) (string, error) {
+	info, err := crateVersionInfo(ctx, pkg)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range info {
+		if info[i].Num == version {
+			return info[i].Checksum, nil
+		}
+	}
+	return "", errors.New("version not found")
+}
+
+// escapeModulePath escapes a module path for use in requests to a Go module proxy, by replacing uppercase letters
+// with an exclamation mark followed by the lowercase letter.
+func escapeModulePath(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if unicode.IsUpper(r) {
+			b.WriteRune('!')
+			b.WriteRune(unicode.ToLower(r))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func goModVersions(ctx context.Context, module string) ([]string, error) {
+	u, err := registryURL(*goproxy, escapeModulePath(module), "@v", "list")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := httpGet(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	list, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if versions := strings.Fields(string(list)); len(versions) > 0 {
+		return versions, nil
+	}
+
+	// Modules without any tagged versions only have a pseudo-version, which is given by @latest.
+	u, err = registryURL(*goproxy, escapeModulePath(module), "@latest")
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Version string `json:"Version"`
+	}
+	if err := getJSON(ctx, u, &res); err != nil {
+		return nil, err
+	}
+	return []string{res.Version}, nil
+}
+
+// goModSha256 returns the checksum of the module zip for the given version.
+func goModSha256(ctx context.Context, module, version string) (string, error) {
+	u, err := registryURL(*goproxy, escapeModulePath(module), "@v", fmt.Sprintf("%s.zip", escapeModulePath(version)))
+	if err != nil {
+		return "", err
+	}
+	return downloadSha256(ctx, u)
+}
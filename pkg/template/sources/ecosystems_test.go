@@ -0,0 +1,151 @@
+package sources
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/csmith/contempt/pkg/materials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEcosystems(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/pypi/black/json":
+			_, _ = w.Write([]byte(`{"releases": {"24.1.0": [{}], "24.2.0": [{"yanked": true}], "24.3.0a1": [{}]}}`))
+		case "/pypi/black/24.1.0/json":
+			_, _ = w.Write([]byte(`{"urls": [
+				{"filename": "black-24.1.0-cp312-cp312-win_amd64.whl", "packagetype": "bdist_wheel", "digests": {"sha256": "win"}},
+				{"filename": "black-24.1.0-py3-none-any.whl", "packagetype": "bdist_wheel", "digests": {"sha256": "wheel"}}
+			]}`))
+		case "/npm/@scope/pkg":
+			_, _ = w.Write([]byte(`{"versions": {"1.0.0": {"dist": {"tarball": "` + "http://" + r.Host + `/tarball"}}}}`))
+		case "/tarball":
+			_, _ = w.Write([]byte("hello"))
+		case "/crates/api/v1/crates/serde/versions":
+			assert.NotEmpty(t, r.Header.Get("User-Agent"))
+			_, _ = w.Write([]byte(`{"versions": [{"num": "1.0.1", "checksum": "abc", "yanked": true}, {"num": "1.0.0", "checksum": "def"}]}`))
+		case "/goproxy/github.com/!burnt!sushi/toml/@v/list":
+			_, _ = w.Write([]byte("v1.3.2\nv1.4.0\n"))
+		case "/goproxy/github.com/!burnt!sushi/toml/@v/v1.4.0.zip":
+			_, _ = w.Write([]byte("hello"))
+		case "/goproxy/example.com/untagged/@v/list":
+			// Untagged modules have an empty list.
+		case "/goproxy/example.com/untagged/@latest":
+			_, _ = w.Write([]byte(`{"Version": "v0.0.0-20190101000000-abcdefabcdef"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	for flag, value := range map[*string]string{
+		pypiURL:   server.URL,
+		npmURL:    server.URL + "/npm/",
+		cratesURL: server.URL + "/crates",
+		goproxy:   server.URL + "/goproxy/",
+	} {
+		original := *flag
+		*flag = value
+		t.Cleanup(func() { *flag = original })
+	}
+
+	const helloSha256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	tests := []struct {
+		name         string
+		versions     func() ([]string, error)
+		wantVersions []string
+		sha256       func() (string, error)
+		wantSha256   string
+	}{
+		{
+			name:         "pypi",
+			versions:     func() ([]string, error) { return pypiVersions(t.Context(), "black") },
+			wantVersions: []string{"24.1.0", "24.3.0a1"},
+			sha256:       func() (string, error) { return pypiSha256(t.Context(), "black", "24.1.0") },
+			wantSha256:   "wheel",
+		},
+		{
+			name:         "npm",
+			versions:     func() ([]string, error) { return npmVersions(t.Context(), "@scope/pkg") },
+			wantVersions: []string{"1.0.0"},
+			sha256:       func() (string, error) { return npmSha256(t.Context(), "@scope/pkg", "1.0.0") },
+			wantSha256:   helloSha256,
+		},
+		{
+			name:         "crate",
+			versions:     func() ([]string, error) { return crateVersions(t.Context(), "serde") },
+			wantVersions: []string{"1.0.0"},
+			sha256:       func() (string, error) { return crateSha256(t.Context(), "serde", "1.0.0") },
+			wantSha256:   "def",
+		},
+		{
+			name:         "gomod",
+			versions:     func() ([]string, error) { return goModVersions(t.Context(), "github.com/BurntSushi/toml") },
+			wantVersions: []string{"v1.3.2", "v1.4.0"},
+			sha256:       func() (string, error) { return goModSha256(t.Context(), "github.com/BurntSushi/toml", "v1.4.0") },
+			wantSha256:   helloSha256,
+		},
+	}
+
+	t.Run("gomod untagged", func(t *testing.T) {
+		bom := materials.BOM{}
+		gomodVersion := GoModSource()(bomWriter(bom))["gomod_version"].(func(string) (string, error))
+
+		v, err := gomodVersion("example.com/untagged")
+		require.NoError(t, err)
+		assert.Equal(t, "v0.0.0-20190101000000-abcdefabcdef", v)
+		assert.Equal(t, materials.BOM{"gomod:example.com/untagged": "v0.0.0-20190101000000-abcdefabcdef"}, bom)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			versions, err := tt.versions()
+			require.NoError(t, err)
+			slices.Sort(versions)
+			assert.Equal(t, tt.wantVersions, versions)
+
+			checksum, err := tt.sha256()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantSha256, checksum)
+		})
+	}
+}
+
+// bomWriter records materials written by template functions in the given BOM.
+type bomWriter materials.BOM
+
+func (b bomWriter) Write(material, version string) {
+	b[material] = version
+}
+
+func TestLatestPypiVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		versions   []string
+		preRelease bool
+		want       string
+	}{
+		{"post-release", []string{"1.9", "2.0", "2.0.post1"}, false, "2.0.post1"},
+		{"later post-release", []string{"2.0.post2", "2.0.post10", "2.0"}, false, "2.0.post10"},
+		{"release after post-release", []string{"2.0.post1", "2.0.1"}, false, "2.0.1"},
+		{"three segment post-release", []string{"2.0.1", "2.0.1.post1"}, false, "2.0.1.post1"},
+		{"dev release ignored", []string{"2.0", "2.1.dev1"}, false, "2.0"},
+		{"dev release", []string{"2.0", "2.1.dev1"}, true, "2.1.dev1"},
+		{"dev release before release", []string{"2.1.dev1", "2.1"}, true, "2.1"},
+		{"pre-release", []string{"2.0", "2.1rc1"}, true, "2.1rc1"},
+		{"unsupported dev release", []string{"2.0", "2.1rc1.dev1"}, true, "2.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := latestPypiVersion(tt.versions, tt.preRelease)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
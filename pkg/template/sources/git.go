@@ -20,19 +20,7 @@ func GitSource() template.FunctionSource {
 	return func(writer template.BomWriter) tt.FuncMap {
 		return tt.FuncMap{
 			"git_tag": func(repo string) (string, error) {
-				tag, _, err := latest.GitTag(
-					context.Background(),
-					repo,
-					&latest.GitTagOptions{
-						Username: *gitTagUser,
-						Password: *gitTagPass,
-						TagOptions: latest.TagOptions{
-							IgnoreDates:      true,
-							IgnoreErrors:     true,
-							IgnorePreRelease: true,
-						},
-					},
-				)
+				tag, err := latestGitTag(template.ContextOf(writer), repo, "", false)
 				if err != nil {
 					return "", err
 				}
@@ -41,20 +29,7 @@ func GitSource() template.FunctionSource {
 			},
 
 			"prefixed_git_tag": func(repo, prefix string) (string, error) {
-				tag, _, err := latest.GitTag(
-					context.Background(),
-					repo,
-					&latest.GitTagOptions{
-						Username: *gitTagUser,
-						Password: *gitTagPass,
-						TagOptions: latest.TagOptions{
-							IgnoreDates:      true,
-							IgnoreErrors:     true,
-							IgnorePreRelease: true,
-							TrimPrefixes:     []string{prefix},
-						},
-					},
-				)
+				tag, err := latestGitTag(template.ContextOf(writer), repo, prefix, false)
 				if err != nil {
 					return "", err
 				}
@@ -63,19 +38,7 @@ func GitSource() template.FunctionSource {
 			},
 
 			"github_tag": func(repo string) (string, error) {
-				tag, _, err := latest.GitTag(
-					context.Background(),
-					fmt.Sprintf("https://github.com/%s", repo),
-					&latest.GitTagOptions{
-						Username: *gitTagUser,
-						Password: *gitTagPass,
-						TagOptions: latest.TagOptions{
-							IgnoreDates:      true,
-							IgnoreErrors:     true,
-							IgnorePreRelease: true,
-						},
-					},
-				)
+				tag, err := latestGitTag(template.ContextOf(writer), fmt.Sprintf("https://github.com/%s", repo), "", false)
 				if err != nil {
 					return "", err
 				}
@@ -84,19 +47,7 @@ func GitSource() template.FunctionSource {
 			},
 
 			"unreleased_git_tag": func(repo string) (string, error) {
-				tag, _, err := latest.GitTag(
-					context.Background(),
-					repo,
-					&latest.GitTagOptions{
-						Username: *gitTagUser,
-						Password: *gitTagPass,
-						TagOptions: latest.TagOptions{
-							IgnoreDates:      true,
-							IgnoreErrors:     true,
-							IgnorePreRelease: false,
-						},
-					},
-				)
+				tag, err := latestGitTag(template.ContextOf(writer), repo, "", true)
 				if err != nil {
 					return "", err
 				}
@@ -105,20 +56,7 @@ func GitSource() template.FunctionSource {
 			},
 
 			"prefixed_unreleased_git_tag": func(repo, prefix string) (string, error) {
-				tag, _, err := latest.GitTag(
-					context.Background(),
-					repo,
-					&latest.GitTagOptions{
-						Username: *gitTagUser,
-						Password: *gitTagPass,
-						TagOptions: latest.TagOptions{
-							IgnoreDates:      true,
-							IgnoreErrors:     true,
-							IgnorePreRelease: false,
-							TrimPrefixes:     []string{prefix},
-						},
-					},
-				)
+				tag, err := latestGitTag(template.ContextOf(writer), repo, prefix, true)
 				if err != nil {
 					return "", err
 				}
@@ -127,26 +65,132 @@ func GitSource() template.FunctionSource {
 			},
 
 			"prefixed_github_tag": func(repo, prefix string) (string, error) {
-				tag, _, err := latest.GitTag(
-					context.Background(),
-					fmt.Sprintf("https://github.com/%s", repo),
-					&latest.GitTagOptions{
-						Username: *gitTagUser,
-						Password: *gitTagPass,
-						TagOptions: latest.TagOptions{
-							IgnoreDates:      true,
-							IgnoreErrors:     true,
-							IgnorePreRelease: true,
-							TrimPrefixes:     []string{prefix},
-						},
-					},
-				)
+				tag, err := latestGitTag(template.ContextOf(writer), fmt.Sprintf("https://github.com/%s", repo), prefix, false)
 				if err != nil {
 					return "", err
 				}
 				writer.Write(fmt.Sprintf("github:%s", repo), strings.TrimPrefix(tag, prefix))
 				return tag, nil
 			},
+
+			"git_commit": func(repo, branch string) (string, error) {
+				commit, err := gitRefCommit(template.ContextOf(writer), repo, fmt.Sprintf("refs/heads/%s", branch))
+				if err != nil {
+					return "", err
+				}
+				writer.Write(fmt.Sprintf("gitref:%s@%s", repo, branch), commit)
+				return commit, nil
+			},
+
+			"git_tag_commit": func(repo, tag string) (string, error) {
+				commit, err := gitRefCommit(template.ContextOf(writer), repo, fmt.Sprintf("refs/tags/%s", tag))
+				if err != nil {
+					return "", err
+				}
+				writer.Write(fmt.Sprintf("gitref:%s@%s", repo, tag), commit)
+				return commit, nil
+			},
+
+			"git_tag_matching": func(repo, constraint string) (string, error) {
+				tag, err := latestGitTagMatching(template.ContextOf(writer), repo, "", constraint)
+				if err != nil {
+					return "", err
+				}
+				writer.Write(fmt.Sprintf("git:%s@%s", repo, constraint), tag)
+				return tag, nil
+			},
+
+			"prefixed_git_tag_matching": func(repo, prefix, constraint string) (string, error) {
+				tag, err := latestGitTagMatching(template.ContextOf(writer), repo, prefix, constraint)
+				if err != nil {
+					return "", err
+				}
+				writer.Write(fmt.Sprintf("git:%s@%s", repo, constraint), strings.TrimPrefix(tag, prefix))
+				return tag, nil
+			},
+
+			"github_tag_matching": func(repo, constraint string) (string, error) {
+				tag, err := latestGitTagMatching(template.ContextOf(writer), fmt.Sprintf("https://github.com/%s", repo), "", constraint)
+				if err != nil {
+					return "", err
+				}
+				writer.Write(fmt.Sprintf("github:%s@%s", repo, constraint), tag)
+				return tag, nil
+			},
+
+			"prefixed_github_tag_matching": func(repo, prefix, constraint string) (string, error) {
+				tag, err := latestGitTagMatching(template.ContextOf(writer), fmt.Sprintf("https://github.com/%s", repo), prefix, constraint)
+				if err != nil {
+					return "", err
+				}
+				writer.Write(fmt.Sprintf("github:%s@%s", repo, constraint), strings.TrimPrefix(tag, prefix))
+				return tag, nil
+			},
 		}
 	}
 }
+
+// latestGitTag returns the latest semver tag in the given repository. If prefix is non-empty, it is removed from tags
+// before they are compared. Pre-release versions are ignored unless preRelease is true.
+func latestGitTag(ctx context.Context, repo, prefix string, preRelease bool) (string, error) {
+	return cached("git", fmt.Sprintf("%s|%s|%t", repo, prefix, preRelease), func() (string, error) {
+		options := &latest.GitTagOptions{
+			Username: *gitTagUser,
+			Password: *gitTagPass,
+			TagOptions: latest.TagOptions{
+				IgnoreDates:      true,
+				IgnoreErrors:     true,
+				IgnorePreRelease: !preRelease,
+			},
+		}
+		if prefix != "" {
+			options.TrimPrefixes = []string{prefix}
+		}
+
+		tag, _, err := latest.GitTag(ctx, repo, options)
+		return tag, err
+	})
+}
+
+// latestGitTagMatching returns the highest semver tag in the given repository that satisfies the constraint (see
+// parseVersionConstraint). If prefix is non-empty, only tags starting with it are considered, and it is removed from
+// tags before they are compared.
+func latestGitTagMatching(ctx context.Context, repo, prefix, constraint string) (string, error) {
+	return cached("git", fmt.Sprintf("%s|%s|%s", repo, prefix, constraint), func() (string, error) {
+		refs, err := listGitRefs(ctx, repo)
+		if err != nil {
+			return "", err
+		}
+
+		var versions []string
+		for _, tag := range gitTags(refs) {
+			if v, ok := strings.CutPrefix(tag, prefix); ok {
+				versions = append(versions, v)
+			}
+		}
+
+		v, err := latestSemverMatching(versions, constraint)
+		if err != nil {
+			return "", fmt.Errorf("no tag in %s matches %s: %v", repo, constraint, err)
+		}
+		return prefix + v, nil
+	})
+}
+
+// gitRefCommit returns the hash of the commit the given ref (e.g. "refs/heads/main") points to in the repository.
+// Annotated tags are peeled, so the commit they refer to is returned rather than the tag object.
+func gitRefCommit(ctx context.Context, repo, ref string) (string, error) {
+	return cached("git", fmt.Sprintf("%s|%s", repo, ref), func() (string, error) {
+		refs, err := listGitRefs(ctx, repo)
+		if err != nil {
+			return "", err
+		}
+
+		for i := range refs {
+			if refs[i].Name == ref {
+				return refs[i].Commit(), nil
+			}
+		}
+		return "", fmt.Errorf("%s not found in %s", ref, repo)
+	})
+}
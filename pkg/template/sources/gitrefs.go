@@ -0,0 +1,155 @@
+package sources
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// gitRef is a single ref advertised by a git server.
+type gitRef struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+	// Peeled is the hash of the commit an annotated tag points to. It is empty for other refs.
+	Peeled string `json:"peeled,omitempty"`
+}
+
+// Commit returns the hash of the commit the ref points to, peeling annotated tags.
+func (r gitRef) Commit() string {
+	if r.Peeled != "" {
+		return r.Peeled
+	}
+	return r.Hash
+}
+
+// listGitRefs returns all refs advertised by the git repository at the given HTTP(S) URL, using the "smart" HTTP
+// protocol (the equivalent of `git ls-remote`). Credentials given by the -git-tag-user and -git-tag-pass flags are
+// sent if set. Repositories using any other protocol (such as SSH) are rejected.
+func listGitRefs(ctx context.Context, repo string) ([]gitRef, error) {
+	if u, err := url.Parse(repo); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("unable to list refs of %s: only repositories served over HTTP(S) are supported", repo)
+	}
+
+	refsURL := fmt.Sprintf("%s/info/refs?service=git-upload-pack", strings.TrimSuffix(repo, "/"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, refsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if *gitTagUser != "" || *gitTagPass != "" {
+		req.SetBasicAuth(*gitTagUser, *gitTagPass)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to list refs of %s: %s", repo, res.Status)
+	}
+
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "application/x-git-upload-pack-advertisement") {
+		return nil, fmt.Errorf("unable to list refs of %s: server does not support the smart HTTP protocol", repo)
+	}
+
+	refs, err := parseGitRefs(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list refs of %s: %v", repo, err)
+	}
+	return refs, nil
+}
+
+// parseGitRefs parses a ref advertisement, which consists of a series of pkt-lines: a service announcement, a flush
+// packet, one line per ref (the first of which also carries the server's capabilities), and a final flush packet.
+// Annotated tags are followed by a line for the peeled ref ("refs/tags/v1.0.0^{}"), which is recorded against the
+// tag.
+func parseGitRefs(r io.Reader) ([]gitRef, error) {
+	reader := bufio.NewReader(r)
+
+	var refs []gitRef
+	flushes := 0
+	for flushes < 2 {
+		line, flush, err := readPktLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		if flush {
+			flushes++
+			continue
+		}
+
+		if strings.HasPrefix(line, "# service=") {
+			continue
+		}
+
+		line, _, _ = strings.Cut(strings.TrimSuffix(line, "\n"), "\x00")
+		hash, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("malformed ref line: %q", line)
+		}
+
+		if name == "capabilities^{}" {
+			// Advertised by empty repositories, which have no refs.
+			continue
+		}
+
+		if peeled, ok := strings.CutSuffix(name, "^{}"); ok {
+			if len(refs) > 0 && refs[len(refs)-1].Name == peeled {
+				refs[len(refs)-1].Peeled = hash
+			}
+			continue
+		}
+
+		refs = append(refs, gitRef{Name: name, Hash: hash})
+	}
+
+	return refs, nil
+}
+
+// readPktLine reads a single pkt-line, returning its payload, or true if it was a flush packet.
+func readPktLine(reader *bufio.Reader) (string, bool, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return "", false, errors.New("unexpected end of ref advertisement")
+		}
+		return "", false, err
+	}
+
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return "", false, fmt.Errorf("malformed pkt-line length: %q", header)
+	}
+
+	if length == 0 {
+		return "", true, nil
+	}
+	if length < 4 {
+		return "", false, fmt.Errorf("malformed pkt-line length: %q", header)
+	}
+
+	payload := make([]byte, length-4)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return "", false, err
+	}
+	return string(payload), false, nil
+}
+
+// gitTags returns the names of all tags in the given refs, without their "refs/tags/" prefix.
+func gitTags(refs []gitRef) []string {
+	var tags []string
+	for i := range refs {
+		if tag, ok := strings.CutPrefix(refs[i].Name, "refs/tags/"); ok {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,142 @@
+package sources
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pktLines encodes the given lines as pkt-lines, with an empty string representing a flush packet.
+func pktLines(lines ...string) string {
+	var b strings.Builder
+	for _, line := range lines {
+		if line == "" {
+			b.WriteString("0000")
+		} else {
+			_, _ = fmt.Fprintf(&b, "%04x%s", len(line)+4, line)
+		}
+	}
+	return b.String()
+}
+
+var testRefAdvertisement = pktLines(
+	"# service=git-upload-pack\n",
+	"",
+	"1111111111111111111111111111111111111111 HEAD\x00multi_ack symref=HEAD:refs/heads/main\n",
+	"1111111111111111111111111111111111111111 refs/heads/main\n",
+	"2222222222222222222222222222222222222222 refs/heads/v1.x\n",
+	"3333333333333333333333333333333333333333 refs/tags/release-1.4.0\n",
+	"4444444444444444444444444444444444444444 refs/tags/v1.4.2\n",
+	"5555555555555555555555555555555555555555 refs/tags/v1.4.2^{}\n",
+	"6666666666666666666666666666666666666666 refs/tags/v2.0.0\n",
+	"",
+)
+
+func TestParseGitRefs(t *testing.T) {
+	refs, err := parseGitRefs(strings.NewReader(testRefAdvertisement))
+	require.NoError(t, err)
+	assert.Equal(t, []gitRef{
+		{Name: "HEAD", Hash: "1111111111111111111111111111111111111111"},
+		{Name: "refs/heads/main", Hash: "1111111111111111111111111111111111111111"},
+		{Name: "refs/heads/v1.x", Hash: "2222222222222222222222222222222222222222"},
+		{Name: "refs/tags/release-1.4.0", Hash: "3333333333333333333333333333333333333333"},
+		{Name: "refs/tags/v1.4.2", Hash: "4444444444444444444444444444444444444444", Peeled: "5555555555555555555555555555555555555555"},
+		{Name: "refs/tags/v2.0.0", Hash: "6666666666666666666666666666666666666666"},
+	}, refs)
+	assert.Equal(t, []string{"release-1.4.0", "v1.4.2", "v2.0.0"}, gitTags(refs))
+
+	_, err = parseGitRefs(strings.NewReader(pktLines("# service=git-upload-pack\n", "")))
+	assert.EqualError(t, err, "unexpected end of ref advertisement")
+
+	refs, err = parseGitRefs(strings.NewReader(pktLines(
+		"# service=git-upload-pack\n",
+		"",
+		"0000000000000000000000000000000000000000 capabilities^{}\x00multi_ack\n",
+		"",
+	)))
+	require.NoError(t, err)
+	assert.Empty(t, refs)
+}
+
+func TestLatestGitTagMatching(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/example.git/info/refs" || r.URL.Query().Get("service") != "git-upload-pack" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		_, _ = w.Write([]byte(testRefAdvertisement))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		prefix     string
+		constraint string
+		want       string
+		wantErr    bool
+	}{
+		{"latest", "", "*", "v2.0.0", false},
+		{"constraint", "", "~1.4", "v1.4.2", false},
+		{"prefix", "release-", "1.x", "release-1.4.0", false},
+		{"no match", "", "^3", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := latestGitTagMatching(t.Context(), server.URL+"/example.git", tt.prefix, tt.constraint)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGitRefCommit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		_, _ = w.Write([]byte(testRefAdvertisement))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr string
+	}{
+		{"refs/heads/main", "1111111111111111111111111111111111111111", ""},
+		{"refs/heads/v1.x", "2222222222222222222222222222222222222222", ""},
+		{"refs/tags/v1.4.2", "5555555555555555555555555555555555555555", ""},
+		{"refs/tags/v2.0.0", "6666666666666666666666666666666666666666", ""},
+		{"refs/heads/missing", "", "refs/heads/missing not found in " + server.URL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := gitRefCommit(t.Context(), server.URL, tt.ref)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestListGitRefs_unsupportedProtocol(t *testing.T) {
+	for _, repo := range []string{"git@github.com:csmith/contempt.git", "ssh://git@github.com/csmith/contempt", "git://example.com/repo", "csmith/contempt"} {
+		t.Run(repo, func(t *testing.T) {
+			_, err := listGitRefs(t.Context(), repo)
+			assert.EqualError(t, err, fmt.Sprintf("unable to list refs of %s: only repositories served over HTTP(S) are supported", repo))
+		})
+	}
+}
@@ -1,38 +1,176 @@
 package sources
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"log"
 	"net/http"
 	"regexp"
 	tt "text/template"
+	"time"
 
+	"github.com/csmith/contempt/pkg/cache"
 	"github.com/csmith/contempt/pkg/template"
 )
 
+const userAgent = "contempt (+https://github.com/csmith/contempt)"
+
 func HttpSource() template.FunctionSource {
 	return func(writer template.BomWriter) tt.FuncMap {
 		return tt.FuncMap{
 			"regex_url_content": func(name, url, regex string) (string, error) {
-				res, err := regexURLContent(url, regex)
+				res, err := cached("regex_url_content", fmt.Sprintf("%s|%s", url, regex), func() (string, error) {
+					return regexURLContent(template.ContextOf(writer), url, regex)
+				})
 				if err != nil {
 					return "", err
 				}
 				writer.Write(fmt.Sprintf("regexurl:%s", name), res)
 				return res, nil
 			},
+
+			"json_url_content": func(name, url, query string) (any, error) {
+				headers, err := urlHeaders(url)
+				if err != nil {
+					return nil, fmt.Errorf("unable to query %s: %v", url, err)
+				}
+
+				res, err := cached("json_url_content", urlQueryCacheKey(url, query, headers), func() (queryResult, error) {
+					return queryURLContent(template.ContextOf(writer), url, query, decodeJSON)
+				})
+				if err != nil {
+					return nil, fmt.Errorf("unable to query %s: %v", url, err)
+				}
+				writer.Write(fmt.Sprintf("jsonurl:%s", name), res.material())
+				return res.value(), nil
+			},
+
+			"yaml_url_content": func(name, url, query string) (any, error) {
+				headers, err := urlHeaders(url)
+				if err != nil {
+					return nil, fmt.Errorf("unable to query %s: %v", url, err)
+				}
+
+				res, err := cached("yaml_url_content", urlQueryCacheKey(url, query, headers), func() (queryResult, error) {
+					return queryURLContent(template.ContextOf(writer), url, query, decodeYAML)
+				})
+				if err != nil {
+					return nil, fmt.Errorf("unable to query %s: %v", url, err)
+				}
+				writer.Write(fmt.Sprintf("jsonurl:%s", name), res.material())
+				return res.value(), nil
+			},
+
+			"url_sha256": func(name, url string) (string, error) {
+				return urlDigestFunc(writer, name, url, "sha256")
+			},
+
+			"url_sha512": func(name, url string) (string, error) {
+				return urlDigestFunc(writer, name, url, "sha512")
+			},
+		}
+	}
+}
+
+// urlDigestFunc implements the url_sha256 and url_sha512 functions.
+func urlDigestFunc(writer template.BomWriter, name, url, algorithm string) (string, error) {
+	c, ttls, err := configuredCache()
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := urlDigest(template.ContextOf(writer), c, ttls["url_digest"], url, algorithm)
+	if err != nil {
+		return "", fmt.Errorf("unable to calculate %s of %s: %v", algorithm, url, err)
+	}
+
+	writer.Write(fmt.Sprintf("url:%s", name), digest)
+	return digest, nil
+}
+
+// urlDigestEntry is the cached digest of a URL, along with the ETag the server gave for it.
+type urlDigestEntry struct {
+	ETag   string `json:"etag"`
+	Digest string `json:"digest"`
+}
+
+// urlDigest downloads the given URL and returns the hex-encoded digest of its content, using the given algorithm
+// (sha256 or sha512).
+//
+// If the server provides an ETag, the digest is stored in the cache alongside it. Subsequent lookups send the ETag
+// back to the server, and if the server reports the content hasn't changed, the cached digest is used without
+// downloading the content again. Content without an ETag is always downloaded.
+func urlDigest(ctx context.Context, c *cache.Cache, ttl time.Duration, url, algorithm string) (string, error) {
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm: %s", algorithm)
+	}
+
+	key := fmt.Sprintf("%s|%s", url, algorithm)
+	var cachedEntry urlDigestEntry
+	if !c.Get("url_digest", key, ttl, &cachedEntry) {
+		cachedEntry = urlDigestEntry{}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if cachedEntry.ETag != "" {
+		req.Header.Set("If-None-Match", cachedEntry.ETag)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified && cachedEntry.ETag != "" {
+		return cachedEntry.Digest, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to retrieve %s: %s", url, res.Status)
+	}
+
+	if _, err := io.Copy(h, res.Body); err != nil {
+		return "", err
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+
+	if etag := res.Header.Get("ETag"); etag != "" {
+		if err := c.Put("url_digest", key, urlDigestEntry{ETag: etag, Digest: digest}); err != nil {
+			log.Printf("Unable to cache digest of %s: %v", url, err)
 		}
 	}
+	return digest, nil
 }
 
-func regexURLContent(url string, regex string) (string, error) {
+func regexURLContent(ctx context.Context, url string, regex string) (string, error) {
 	re, err := regexp.Compile(regex)
 	if err != nil {
 		return "", err
 	}
 
-	res, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -50,3 +188,36 @@ func regexURLContent(url string, regex string) (string, error) {
 	}
 	return string(result[1]), nil
 }
+
+// header is an additional HTTP header to send with a request. Headers with empty values are not sent.
+type header struct {
+	name  string
+	value string
+}
+
+// httpGet performs a GET request for the given URL, returning the body of the response if it was successful. The
+// caller must close the body. Requests identify themselves with a User-Agent header, as some registries (such as
+// crates.io) reject anonymous requests.
+func httpGet(ctx context.Context, url string, headers ...header) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	for i := range headers {
+		if headers[i].value != "" {
+			req.Header.Set(headers[i].name, headers[i].value)
+		}
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		_ = res.Body.Close()
+		return nil, fmt.Errorf("unable to retrieve %s: %s", url, res.Status)
+	}
+	return res.Body, nil
+}
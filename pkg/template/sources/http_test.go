@@ -0,0 +1,64 @@
+package sources
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/csmith/contempt/pkg/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUrlDigest(t *testing.T) {
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/etag" {
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		downloads++
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	c := cache.New(t.TempDir(), false)
+
+	const (
+		helloSha256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+		helloSha512 = "9b71d224bd62f3785d96d46ad3ea3d73319bfbc2890caadae2dff72519673ca72323c3d99ba5c11d7c7acc6e14b8c5da0c4663475c2e5c3adef46f73bcdec043"
+	)
+
+	tests := []struct {
+		name          string
+		path          string
+		algorithm     string
+		want          string
+		wantDownloads int
+		wantErr       bool
+	}{
+		{"sha256 with etag", "/etag", "sha256", helloSha256, 1, false},
+		{"sha256 with etag from cache", "/etag", "sha256", helloSha256, 1, false},
+		{"sha512 with etag", "/etag", "sha512", helloSha512, 2, false},
+		{"sha256 without etag", "/plain", "sha256", helloSha256, 3, false},
+		{"sha256 without etag is not cached", "/plain", "sha256", helloSha256, 4, false},
+		{"unsupported algorithm", "/plain", "md5", "", 4, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := urlDigest(t.Context(), c, time.Hour, server.URL+tt.path, tt.algorithm)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+			assert.Equal(t, tt.wantDownloads, downloads)
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package sources
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// packageInfo contains the details of a single package from a distribution's package index.
+type packageInfo struct {
+	Name    string
+	Version string
+	// Dependencies contains one entry per dependency, each listing the acceptable alternatives in order of preference.
+	Dependencies [][]string
+	// Provides lists the virtual packages (or other capabilities) that this package provides.
+	Provides []string
+}
+
+// packageIndex contains all the packages available from a distribution's repositories, along with the packages that
+// provide each virtual package.
+type packageIndex struct {
+	packages  map[string]*packageInfo
+	providers map[string][]string
+	compare   func(a, b string) int
+}
+
+// newPackageIndex creates an empty index that uses the given function to compare package versions.
+func newPackageIndex(compare func(a, b string) int) *packageIndex {
+	return &packageIndex{
+		packages:  make(map[string]*packageInfo),
+		providers: make(map[string][]string),
+		compare:   compare,
+	}
+}
+
+// add adds a package to the index. If the package is already in the index, the highest version is kept.
+func (i *packageIndex) add(p *packageInfo) {
+	if existing, ok := i.packages[p.Name]; !ok || i.compare(p.Version, existing.Version) > 0 {
+		i.packages[p.Name] = p
+	}
+	for _, virtual := range p.Provides {
+		i.providers[virtual] = append(i.providers[virtual], p.Name)
+	}
+}
+
+// finish must be called once all packages have been added to the index, before it is used to resolve packages.
+func (i *packageIndex) finish() {
+	for virtual := range i.providers {
+		slices.Sort(i.providers[virtual])
+		i.providers[virtual] = slices.Compact(i.providers[virtual])
+	}
+}
+
+// resolve finds the given packages and all of their dependencies in the index. Where a dependency has alternatives,
+// the first alternative that has already been selected is used; otherwise the first one that exists in the index.
+func (i *packageIndex) resolve(names ...string) (map[string]string, error) {
+	res := make(map[string]string)
+
+	var queue [][]string
+	for _, n := range names {
+		queue = append(queue, []string{n})
+	}
+
+	for len(queue) > 0 {
+		alternatives := queue[0]
+		queue = queue[1:]
+
+		if slices.ContainsFunc(alternatives, i.satisfiedBy(res)) {
+			// We've already got a resolution for this dependency, skip it.
+			continue
+		}
+
+		var p *packageInfo
+		for _, alternative := range alternatives {
+			if p = i.find(alternative); p != nil {
+				break
+			}
+		}
+
+		if p == nil {
+			return nil, fmt.Errorf("package required but not found: %s", strings.Join(alternatives, " | "))
+		}
+
+		res[p.Name] = p.Version
+		queue = append(queue, p.Dependencies...)
+	}
+
+	return res, nil
+}
+
+// satisfiedBy returns a function that determines whether a package (real or virtual) is provided by the given
+// selection of packages.
+func (i *packageIndex) satisfiedBy(selected map[string]string) func(string) bool {
+	return func(name string) bool {
+		if _, ok := selected[name]; ok {
+			return true
+		}
+		for _, provider := range i.providers[name] {
+			if _, ok := selected[provider]; ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// find returns the package with the given name, or if there is no such package, the first package (alphabetically)
+// that provides it. Returns nil if the package can't be found.
+func (i *packageIndex) find(name string) *packageInfo {
+	if p, ok := i.packages[name]; ok {
+		return p
+	}
+	if providers := i.providers[name]; len(providers) > 0 {
+		return i.packages[providers[0]]
+	}
+	return nil
+}
+
+// splitEpoch splits a version into its epoch (defaulting to zero) and the rest of the version.
+func splitEpoch(version string) (string, string) {
+	if epoch, rest, ok := strings.Cut(version, ":"); ok {
+		return epoch, rest
+	}
+	return "0", version
+}
+
+// splitRevision splits a version into its upstream version and its revision (or release), which follows the last
+// hyphen.
+func splitRevision(version string) (string, string) {
+	if index := strings.LastIndexByte(version, '-'); index != -1 {
+		return version[:index], version[index+1:]
+	}
+	return version, ""
+}
+
+// splitDigits splits the given string after its leading run of digits (or non-digits).
+func splitDigits(s string, digits bool) (string, string) {
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9') == digits {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// compareNumbers compares two strings of digits numerically, treating empty strings as zero.
+func compareNumbers(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,517 @@
+package sources
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/csmith/contempt/internal"
+)
+
+// manifestMediaTypes are the manifest types accepted from registries: both indexes (which list a manifest for each
+// platform) and single-platform manifests, in their OCI and Docker forms.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// imageRef is a reference to an image in a registry, such as "docker.io/library/alpine:3.20".
+type imageRef struct {
+	// Registry is the host (and optional port) of the registry, as used in image names.
+	Registry string
+	// Repository is the path of the repository within the registry, e.g. "library/alpine".
+	Repository string
+	// Reference is the tag or digest of the image.
+	Reference string
+	// Configured is whether the image is in the default registry, whose credentials can be given by the
+	// -registry-user and -registry-pass flags.
+	Configured bool
+}
+
+// parseImageRef parses an image reference. If the reference doesn't include a registry then defaultRegistry is
+// used, and if it doesn't include a tag or digest then "latest" is used.
+func parseImageRef(defaultRegistry, ref string) imageRef {
+	var res imageRef
+
+	name := ref
+	if i := strings.IndexByte(name, '@'); i != -1 {
+		name, res.Reference = name[:i], name[i+1:]
+	} else if i := strings.LastIndexByte(name, ':'); i != -1 && i > strings.LastIndexByte(name, '/') {
+		name, res.Reference = name[:i], name[i+1:]
+	} else {
+		res.Reference = "latest"
+	}
+
+	if internal.HasRegistry(name) {
+		res.Registry, res.Repository, _ = strings.Cut(name, "/")
+	} else {
+		res.Registry = defaultRegistry
+		res.Repository = name
+	}
+
+	res.Configured = res.Registry == defaultRegistry
+	if res.Registry == "docker.io" && !strings.Contains(res.Repository, "/") {
+		res.Repository = fmt.Sprintf("library/%s", res.Repository)
+	}
+
+	return res
+}
+
+// qualifiedImage returns the image reference prefixed with the given registry, unless it already includes one.
+func qualifiedImage(registry, ref string) string {
+	if internal.HasRegistry(ref) {
+		return ref
+	}
+	return fmt.Sprintf("%s/%s", registry, ref)
+}
+
+// Name returns the fully-qualified name of the image, without a tag or digest.
+func (r imageRef) Name() string {
+	return fmt.Sprintf("%s/%s", r.Registry, r.Repository)
+}
+
+// url returns the URL of the given path under the repository in the registry's API. Registries on localhost are
+// accessed over plain HTTP, all others use HTTPS.
+func (r imageRef) url(path string) string {
+	host := r.Registry
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	scheme := "https"
+	if hostname == "localhost" || hostname == "127.0.0.1" {
+		scheme = "http"
+	}
+
+	return fmt.Sprintf("%s://%s/v2/%s/%s", scheme, host, r.Repository, path)
+}
+
+// controlsRealm determines whether the token realm is served by the registry itself, or by another host in the
+// registry's domain (such as auth.docker.io for registry-1.docker.io).
+func (r imageRef) controlsRealm(realm string) bool {
+	registryURL, err := url.Parse(r.url(""))
+	if err != nil {
+		return false
+	}
+
+	realmURL, err := url.Parse(realm)
+	if err != nil || (realmURL.Scheme != "https" && realmURL.Scheme != registryURL.Scheme) {
+		return false
+	}
+
+	host := registryURL.Hostname()
+	if realmURL.Hostname() == host {
+		return true
+	}
+	if net.ParseIP(host) != nil || realmURL.Scheme != "https" {
+		return false
+	}
+
+	domain := host
+	if strings.Count(host, ".") > 1 {
+		_, domain, _ = strings.Cut(host, ".")
+	}
+	return strings.HasSuffix(realmURL.Hostname(), "."+domain)
+}
+
+// registryPlatform is the platform an image manifest is for.
+type registryPlatform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// parsePlatform parses a platform in the form "os/arch" or "os/arch/variant", e.g. "linux/arm64" or "linux/arm/v7".
+func parsePlatform(platform string) (registryPlatform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return registryPlatform{}, fmt.Errorf("invalid platform %q, expected os/arch or os/arch/variant", platform)
+	}
+
+	res := registryPlatform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		res.Variant = parts[2]
+	}
+	return res, nil
+}
+
+func (p registryPlatform) String() string {
+	if p.Variant != "" {
+		return fmt.Sprintf("%s/%s/%s", p.OS, p.Architecture, p.Variant)
+	}
+	return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
+}
+
+// matches determines whether this platform satisfies the requested one. A request that doesn't specify a variant is
+// satisfied by any variant.
+func (p registryPlatform) matches(requested registryPlatform) bool {
+	return p.OS == requested.OS &&
+		p.Architecture == requested.Architecture &&
+		(requested.Variant == "" || p.Variant == requested.Variant)
+}
+
+// registryManifest is the subset of an image index or manifest that we care about.
+type registryManifest struct {
+	MediaType string `json:"mediaType"`
+	Manifests []struct {
+		MediaType string            `json:"mediaType"`
+		Digest    string            `json:"digest"`
+		Platform  *registryPlatform `json:"platform"`
+	} `json:"manifests"`
+	Config struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+}
+
+// isIndex determines whether the manifest is an index of per-platform manifests.
+func (m registryManifest) isIndex() bool {
+	return m.Manifests != nil ||
+		m.MediaType == "application/vnd.oci.image.index.v1+json" ||
+		m.MediaType == "application/vnd.docker.distribution.manifest.list.v2+json"
+}
+
+// fetchManifest retrieves the manifest for the image, returning it along with its digest.
+func fetchManifest(ctx context.Context, ref imageRef) (registryManifest, string, error) {
+	res, err := registryGet(ctx, ref, fmt.Sprintf("manifests/%s", ref.Reference), manifestMediaTypes...)
+	if err != nil {
+		return registryManifest{}, "", err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return registryManifest{}, "", err
+	}
+
+	var manifest registryManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return registryManifest{}, "", fmt.Errorf("unable to parse manifest for %s: %v", ref.Name(), err)
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType, _, _ = strings.Cut(res.Header.Get("Content-Type"), ";")
+	}
+
+	digest := res.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		sum := sha256.Sum256(body)
+		digest = fmt.Sprintf("sha256:%s", hex.EncodeToString(sum[:]))
+	}
+	return manifest, digest, nil
+}
+
+// imageDigest returns the digest of the manifest the image reference points to: an index for multi-platform images,
+// or the manifest itself for single-platform images.
+func imageDigest(ctx context.Context, ref imageRef) (string, error) {
+	_, digest, err := fetchManifest(ctx, ref)
+	return digest, err
+}
+
+// fetchImagePlatform retrieves the platform of a single-platform image manifest from its config blob.
+func fetchImagePlatform(ctx context.Context, ref imageRef, manifest registryManifest) (registryPlatform, error) {
+	res, err := registryGet(ctx, ref, fmt.Sprintf("blobs/%s", manifest.Config.Digest))
+	if err != nil {
+		return registryPlatform{}, err
+	}
+	defer res.Body.Close()
+
+	var platform registryPlatform
+	if err := json.NewDecoder(res.Body).Decode(&platform); err != nil {
+		return registryPlatform{}, fmt.Errorf("unable to parse config for %s: %v", ref.Name(), err)
+	}
+	return platform, nil
+}
+
+// listImageTags returns all tags in the image's repository, following pagination links.
+func listImageTags(ctx context.Context, ref imageRef) ([]string, error) {
+	var tags []string
+	path := "tags/list?n=1000"
+	for path != "" {
+		res, err := registryGet(ctx, ref, path)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse tags for %s: %v", ref.Name(), err)
+		}
+
+		tags = append(tags, page.Tags...)
+		path = nextTagsPath(ref, res.Header.Get("Link"))
+	}
+	return tags, nil
+}
+
+// nextTagsPath extracts the path of the next page of tags from a Link header, such as
+// `</v2/library/node/tags/list?last=22&n=1000>; rel="next"`, relative to the repository.
+func nextTagsPath(ref imageRef, link string) string {
+	target, params, ok := strings.Cut(link, ";")
+	if !ok || !strings.Contains(params, `rel="next"`) {
+		return ""
+	}
+
+	next, err := url.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+	if err != nil {
+		return ""
+	}
+
+	path, ok := strings.CutPrefix(next.Path, fmt.Sprintf("/v2/%s/", ref.Repository))
+	if !ok {
+		return ""
+	}
+	if next.RawQuery != "" {
+		path = fmt.Sprintf("%s?%s", path, next.RawQuery)
+	}
+	return path
+}
+
+// imageTag is a tag selected from an image's repository, along with the digest it points to.
+type imageTag struct {
+	Tag    string `json:"tag"`
+	Digest string `json:"digest"`
+}
+
+// latestImageTag finds the highest tag of the image that ends with the given suffix and, once the suffix is removed,
+// satisfies the version constraint (see parseVersionConstraint). It returns the tag along with its digest.
+func latestImageTag(ctx context.Context, ref imageRef, constraint, suffix string) (imageTag, error) {
+	tags, err := listImageTags(ctx, ref)
+	if err != nil {
+		return imageTag{}, err
+	}
+
+	var versions []string
+	for _, tag := range tags {
+		if v, ok := strings.CutSuffix(tag, suffix); ok {
+			versions = append(versions, v)
+		}
+	}
+
+	v, err := latestSemverMatching(versions, constraint)
+	if err != nil {
+		return imageTag{}, fmt.Errorf("no tag of %s matches %q with suffix %q: %v", ref.Name(), constraint, suffix, err)
+	}
+
+	ref.Reference = v + suffix
+	_, digest, err := fetchManifest(ctx, ref)
+	if err != nil {
+		return imageTag{}, err
+	}
+	return imageTag{Tag: ref.Reference, Digest: digest}, nil
+}
+
+// imagePlatformDigests contains the digests resolved for a specific platform of an image.
+type imagePlatformDigests struct {
+	// Index is the digest of the image the reference points to: an index for multi-platform images, or the manifest
+	// itself for single-platform images.
+	Index string `json:"index"`
+	// Platform is the digest of the manifest for the requested platform.
+	Platform string `json:"platform"`
+}
+
+// resolveImagePlatform finds the digest of the manifest for the given platform of an image. It fails if the image
+// isn't available for that platform.
+func resolveImagePlatform(ctx context.Context, ref imageRef, platform string) (imagePlatformDigests, error) {
+	requested, err := parsePlatform(platform)
+	if err != nil {
+		return imagePlatformDigests{}, err
+	}
+
+	manifest, digest, err := fetchManifest(ctx, ref)
+	if err != nil {
+		return imagePlatformDigests{}, err
+	}
+
+	var available []string
+	if manifest.isIndex() {
+		for i := range manifest.Manifests {
+			p := manifest.Manifests[i].Platform
+			if p == nil || p.OS == "unknown" {
+				// Attestations and other artifacts that aren't runnable images.
+				continue
+			}
+			if p.matches(requested) {
+				return imagePlatformDigests{Index: digest, Platform: manifest.Manifests[i].Digest}, nil
+			}
+			available = append(available, p.String())
+		}
+	} else {
+		p, err := fetchImagePlatform(ctx, ref, manifest)
+		if err != nil {
+			return imagePlatformDigests{}, err
+		}
+		if p.matches(requested) {
+			return imagePlatformDigests{Index: digest, Platform: digest}, nil
+		}
+		available = append(available, p.String())
+	}
+
+	return imagePlatformDigests{}, fmt.Errorf(
+		"image %s:%s is not available for platform %s (available: %s)",
+		ref.Name(),
+		ref.Reference,
+		requested,
+		strings.Join(available, ", "),
+	)
+}
+
+// registryGet performs a GET request against the registry API for the given image, handling any authentication
+// challenge using the credentials returned by [registryCredentials].
+func registryGet(ctx context.Context, ref imageRef, path string, accept ...string) (*http.Response, error) {
+	url := ref.url(path)
+
+	do := func(authorization string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", userAgent)
+		if len(accept) > 0 {
+			req.Header.Set("Accept", strings.Join(accept, ", "))
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		return http.DefaultClient.Do(req)
+	}
+
+	res, err := do("")
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusUnauthorized {
+		challenge := res.Header.Get("WWW-Authenticate")
+		res.Body.Close()
+
+		authorization, err := registryAuthorization(ctx, ref, challenge)
+		if err != nil {
+			return nil, fmt.Errorf("unable to authenticate to %s: %v", ref.Registry, err)
+		}
+
+		res, err = do(authorization)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("unable to get %s: %s", url, res.Status)
+	}
+	return res, nil
+}
+
+// registryAuthorization responds to a WWW-Authenticate challenge from a registry, returning the value to send in
+// the Authorization header. Bearer challenges are answered by requesting a token from the given realm, which is only
+// sent the registry's credentials if the registry controls it.
+func registryAuthorization(ctx context.Context, ref imageRef, challenge string) (string, error) {
+	username, password := registryCredentials(ref)
+
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if username == "" && password == "" {
+			return "", fmt.Errorf("registry requires credentials")
+		}
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(username, password)
+		return req.Header.Get("Authorization"), nil
+
+	case "bearer":
+		values := parseChallengeParams(params)
+		if values["realm"] == "" {
+			return "", fmt.Errorf("bearer challenge has no realm")
+		}
+
+		query := url.Values{}
+		for _, key := range []string{"service", "scope"} {
+			if values[key] != "" {
+				query.Set(key, values[key])
+			}
+		}
+
+		tokenURL := values["realm"]
+		if len(query) > 0 {
+			tokenURL = fmt.Sprintf("%s?%s", tokenURL, query.Encode())
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("User-Agent", userAgent)
+		if (username != "" || password != "") && ref.controlsRealm(values["realm"]) {
+			req.SetBasicAuth(username, password)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("unable to get token: %s", res.Status)
+		}
+
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+			return "", fmt.Errorf("unable to parse token: %v", err)
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		return fmt.Sprintf("Bearer %s", token.Token), nil
+
+	default:
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+}
+
+// parseChallengeParams parses the comma-separated key="value" parameters of a WWW-Authenticate challenge.
+func parseChallengeParams(params string) map[string]string {
+	res := make(map[string]string)
+	for params != "" {
+		key, rest, ok := strings.Cut(strings.TrimLeft(params, ", "), "=")
+		if !ok {
+			break
+		}
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end == -1 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+
+		res[strings.ToLower(strings.TrimSpace(key))] = value
+		params = rest
+	}
+	return res
+}
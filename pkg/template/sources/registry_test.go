@@ -0,0 +1,329 @@
+package sources
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want imageRef
+	}{
+		{"alpine", imageRef{"reg.example.com", "alpine", "latest", true}},
+		{"alpine:3.20", imageRef{"reg.example.com", "alpine", "3.20", true}},
+		{"reg.example.com/alpine", imageRef{"reg.example.com", "alpine", "latest", true}},
+		{"docker.io/alpine", imageRef{"docker.io", "library/alpine", "latest", false}},
+		{"ghcr.io/csmith/contempt@sha256:abcd", imageRef{"ghcr.io", "csmith/contempt", "sha256:abcd", false}},
+		{"localhost:5000/tools/go:1.23", imageRef{"localhost:5000", "tools/go", "1.23", false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseImageRef("reg.example.com", tt.ref))
+		})
+	}
+}
+
+func TestResolveImagePlatform(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			assert.Equal(t, "repository:library/alpine:pull", r.URL.Query().Get("scope"))
+			_, _ = w.Write([]byte(`{"token": "secret"}`))
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="registry",scope="repository:library/alpine:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v2/library/alpine/manifests/latest":
+			assert.True(t, strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json"))
+			w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+			w.Header().Set("Docker-Content-Digest", "sha256:index")
+			_, _ = w.Write([]byte(`{"mediaType": "application/vnd.oci.image.index.v1+json", "manifests": [
+				{"digest": "sha256:amd64", "platform": {"os": "linux", "architecture": "amd64"}},
+				{"digest": "sha256:armv7", "platform": {"os": "linux", "architecture": "arm", "variant": "v7"}},
+				{"digest": "sha256:arm64", "platform": {"os": "linux", "architecture": "arm64", "variant": "v8"}},
+				{"digest": "sha256:attestation", "platform": {"os": "unknown", "architecture": "unknown"}}
+			]}`))
+		case "/v2/library/alpine/manifests/single":
+			w.Header().Set("Docker-Content-Digest", "sha256:single")
+			_, _ = w.Write([]byte(`{"mediaType": "application/vnd.oci.image.manifest.v1+json", "config": {"digest": "sha256:config"}}`))
+		case "/v2/library/alpine/blobs/sha256:config":
+			_, _ = w.Write([]byte(`{"os": "linux", "architecture": "amd64"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	tests := []struct {
+		name     string
+		ref      string
+		platform string
+		want     imagePlatformDigests
+		wantErr  string
+	}{
+		{
+			name:     "platform in index",
+			ref:      "library/alpine",
+			platform: "linux/amd64",
+			want:     imagePlatformDigests{Index: "sha256:index", Platform: "sha256:amd64"},
+		},
+		{
+			name:     "platform without variant",
+			ref:      "library/alpine",
+			platform: "linux/arm64",
+			want:     imagePlatformDigests{Index: "sha256:index", Platform: "sha256:arm64"},
+		},
+		{
+			name:     "platform with variant",
+			ref:      "library/alpine:latest",
+			platform: "linux/arm/v7",
+			want:     imagePlatformDigests{Index: "sha256:index", Platform: "sha256:armv7"},
+		},
+		{
+			name:     "single platform manifest",
+			ref:      "library/alpine:single",
+			platform: "linux/amd64",
+			want:     imagePlatformDigests{Index: "sha256:single", Platform: "sha256:single"},
+		},
+		{
+			name:     "missing platform in index",
+			ref:      "library/alpine",
+			platform: "linux/s390x",
+			wantErr:  "image " + registry + "/library/alpine:latest is not available for platform linux/s390x (available: linux/amd64, linux/arm/v7, linux/arm64/v8)",
+		},
+		{
+			name:     "missing platform in single manifest",
+			ref:      "library/alpine:single",
+			platform: "linux/arm64",
+			wantErr:  "image " + registry + "/library/alpine:single is not available for platform linux/arm64 (available: linux/amd64)",
+		},
+		{
+			name:     "invalid platform",
+			ref:      "library/alpine",
+			platform: "arm64",
+			wantErr:  `invalid platform "arm64", expected os/arch or os/arch/variant`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveImagePlatform(t.Context(), parseImageRef(registry, tt.ref), tt.platform)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRegistryCredentials(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"auths": {
+		"https://index.docker.io/v1/": {"auth": "aHViOmh1Yi1wYXNz"},
+		"ghcr.io": {"username": "gh", "password": "gh-pass"},
+		"reg.example.com": {"auth": "Y29uZmlnOmNvbmZpZy1wYXNz"}
+	}}`), 0600))
+
+	tests := []struct {
+		name         string
+		ref          string
+		flags        bool
+		wantUser     string
+		wantPassword string
+	}{
+		{"configured registry with flags", "alpine", true, "user", "pass"},
+		{"configured registry without flags", "alpine", false, "config", "config-pass"},
+		{"other registry with flags", "ghcr.io/csmith/contempt", true, "gh", "gh-pass"},
+		{"docker hub", "docker.io/alpine", true, "hub", "hub-pass"},
+		{"unknown registry", "quay.io/example/app", true, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.flags {
+				*registryUser, *registryPass = "user", "pass"
+				defer func() { *registryUser, *registryPass = "", "" }()
+			}
+
+			user, password := registryCredentials(parseImageRef("reg.example.com", tt.ref))
+			assert.Equal(t, tt.wantUser, user)
+			assert.Equal(t, tt.wantPassword, password)
+		})
+	}
+}
+
+func TestRegistryCredentials_helpers(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{
+		"auths": {"quay.io": {"username": "stored", "password": "stored-pass"}},
+		"credHelpers": {"quay.io": "test"}
+	}`), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docker-credential-test"), []byte("#!/bin/sh\necho '{\"Username\": \"helper\", \"Secret\": \"helper-pass\"}'\n"), 0700))
+
+	ref := parseImageRef("reg.example.com", "quay.io/example/app")
+
+	user, password := registryCredentials(ref)
+	assert.Equal(t, "stored", user)
+	assert.Equal(t, "stored-pass", password)
+
+	*registryCredentialHelpers = true
+	defer func() { *registryCredentialHelpers = false }()
+
+	user, password = registryCredentials(ref)
+	assert.Equal(t, "helper", user)
+	assert.Equal(t, "helper-pass", password)
+}
+
+func TestImageDigest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "pass" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v2/library/alpine/manifests/latest":
+			w.Header().Set("Docker-Content-Digest", "sha256:index")
+			_, _ = w.Write([]byte(`{"mediaType": "application/vnd.oci.image.index.v1+json", "manifests": []}`))
+		case "/v2/library/alpine/manifests/3.20":
+			// Registries aren't required to send the digest, in which case it's calculated from the manifest.
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+	*registryUser, *registryPass = "user", "pass"
+	defer func() { *registryUser, *registryPass = "", "" }()
+
+	digest, err := imageDigest(t.Context(), parseImageRef(registry, "library/alpine"))
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:index", digest)
+
+	digest, err = imageDigest(t.Context(), parseImageRef(registry, "library/alpine:3.20"))
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a", digest)
+
+	_, err = imageDigest(t.Context(), parseImageRef(registry, "library/alpine:missing"))
+	assert.ErrorContains(t, err, "404 Not Found")
+}
+
+func TestControlsRealm(t *testing.T) {
+	tests := []struct {
+		ref   string
+		realm string
+		want  bool
+	}{
+		{"ghcr.io/csmith/contempt", "https://ghcr.io/token", true},
+		{"docker.io/alpine", "https://auth.docker.io/token", true},
+		{"reg.example.com/alpine", "https://auth.example.com/token", true},
+		{"reg.example.com/alpine", "http://reg.example.com/token", false},
+		{"reg.example.com/alpine", "https://auth.docker.io/token", false},
+		{"ghcr.io/csmith/contempt", "https://evil.io/token", false},
+		{"localhost:5000/alpine", "http://localhost:5001/token", true},
+		{"127.0.0.1:5000/alpine", "http://localhost/token", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref+" "+tt.realm, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseImageRef("docker.io", tt.ref).controlsRealm(tt.realm))
+		})
+	}
+}
+
+func TestLatestImageTag(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/library/node/tags/list":
+			if r.URL.Query().Get("last") == "" {
+				w.Header().Set("Link", `</v2/library/node/tags/list?last=22-alpine&n=1000>; rel="next"`)
+				_, _ = w.Write([]byte(`{"name": "library/node", "tags": ["20.18.0", "20.18.0-alpine", "22", "22-alpine"]}`))
+			} else {
+				assert.Equal(t, "22-alpine", r.URL.Query().Get("last"))
+				_, _ = w.Write([]byte(`{"name": "library/node", "tags": ["22.11", "22.11-alpine", "22.11.0", "22.11.0-alpine", "23.0.0-rc.1", "latest"]}`))
+			}
+		case "/v2/library/node/manifests/22.11.0-alpine":
+			w.Header().Set("Docker-Content-Digest", "sha256:alpine")
+			_, _ = w.Write([]byte(`{"mediaType": "application/vnd.oci.image.index.v1+json", "manifests": []}`))
+		case "/v2/library/node/manifests/22.11.0":
+			w.Header().Set("Docker-Content-Digest", "sha256:debian")
+			_, _ = w.Write([]byte(`{"mediaType": "application/vnd.oci.image.index.v1+json", "manifests": []}`))
+		case "/v2/library/node/manifests/20.18.0":
+			w.Header().Set("Docker-Content-Digest", "sha256:old")
+			_, _ = w.Write([]byte(`{"mediaType": "application/vnd.oci.image.index.v1+json", "manifests": []}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	tests := []struct {
+		name       string
+		constraint string
+		suffix     string
+		want       imageTag
+		wantErr    string
+	}{
+		{
+			name:       "suffix",
+			constraint: "^22",
+			suffix:     "-alpine",
+			want:       imageTag{Tag: "22.11.0-alpine", Digest: "sha256:alpine"},
+		},
+		{
+			name:       "no suffix ignores variants and pre-releases",
+			constraint: ">=20",
+			want:       imageTag{Tag: "22.11.0", Digest: "sha256:debian"},
+		},
+		{
+			name:       "older major",
+			constraint: "20.x",
+			want:       imageTag{Tag: "20.18.0", Digest: "sha256:old"},
+		},
+		{
+			name:       "no match",
+			constraint: "^24",
+			suffix:     "-alpine",
+			wantErr:    "no tag of " + registry + `/library/node matches "^24" with suffix "-alpine": no matching versions found`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := latestImageTag(t.Context(), parseImageRef(registry, "library/node"), tt.constraint, tt.suffix)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
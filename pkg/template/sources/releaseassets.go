@@ -0,0 +1,200 @@
+package sources
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	tt "text/template"
+
+	"github.com/csmith/contempt/pkg/template"
+)
+
+var (
+	githubAPIURL = flag.String("github-api-url", "https://api.github.com/", "Base URL of the GitHub API to query releases from")
+	githubToken  = flag.String("github-token", "", "Token to use when querying releases from GitHub")
+	giteaToken   = flag.String("gitea-token", "", "Token to use when querying releases from Gitea or Forgejo")
+)
+
+func ReleaseAssetSource() template.FunctionSource {
+	return func(writer template.BomWriter) tt.FuncMap {
+		return tt.FuncMap{
+			"release_asset": func(repo, assetPattern string, checksumsPattern ...string) (map[string]string, error) {
+				if len(checksumsPattern) > 1 {
+					return nil, fmt.Errorf("release_asset takes at most one checksums file pattern")
+				}
+
+				key, err := releaseAssetCacheKey(repo, assetPattern, strings.Join(checksumsPattern, ""))
+				if err != nil {
+					return nil, fmt.Errorf("unable to find release asset for %s: %v", repo, err)
+				}
+
+				res, err := cached("release_asset", key, func() (map[string]string, error) {
+					return latestReleaseAsset(template.ContextOf(writer), repo, assetPattern, strings.Join(checksumsPattern, ""))
+				})
+				if err != nil {
+					return nil, fmt.Errorf("unable to find release asset for %s: %v", repo, err)
+				}
+
+				writer.Write(fmt.Sprintf("release:%s", repo), res["tag"])
+				return res, nil
+			},
+		}
+	}
+}
+
+// forgeRelease is the subset of a release returned by the GitHub and Gitea/Forgejo APIs that we care about. Both
+// APIs use the same format.
+type forgeRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name        string `json:"name"`
+		DownloadURL string `json:"browser_download_url"`
+		// Digest is only provided by GitHub, in the form "sha256:<hex>".
+		Digest string `json:"digest"`
+	} `json:"assets"`
+}
+
+// releaseAPI returns the URL of the latest release of the given repository, along with any headers needed to
+// authenticate with its API. Repositories given as "owner/repo" are looked up on GitHub; repositories given as full
+// URLs are looked up using the Gitea/Forgejo API on the same host, unless the host is github.com.
+func releaseAPI(repo string) (string, []header, error) {
+	if !strings.Contains(repo, "://") {
+		u, err := registryURL(*githubAPIURL, "repos", repo, "releases", "latest")
+		return u, githubHeaders(), err
+	}
+
+	u, err := url.Parse(repo)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ownerAndRepo := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	if u.Host == "github.com" {
+		api, err := registryURL(*githubAPIURL, "repos", ownerAndRepo, "releases", "latest")
+		return api, githubHeaders(), err
+	}
+
+	u.Path = ""
+	api, err := registryURL(u.String(), "api", "v1", "repos", ownerAndRepo, "releases", "latest")
+	var headers []header
+	if *giteaToken != "" {
+		headers = append(headers, header{name: "Authorization", value: fmt.Sprintf("token %s", *giteaToken)})
+	}
+	return api, headers, err
+}
+
+// releaseAssetCacheKey returns the key that the asset matching the given patterns in the latest release of the
+// repository is cached under. It includes the API URL the release is looked up from, so that results from different
+// instances (e.g. if -github-api-url is changed) are cached separately.
+func releaseAssetCacheKey(repo, assetPattern, checksumsPattern string) (string, error) {
+	api, _, err := releaseAPI(repo)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s|%s|%s", api, assetPattern, checksumsPattern), nil
+}
+
+func githubHeaders() []header {
+	headers := []header{{name: "Accept", value: "application/vnd.github+json"}}
+	if *githubToken != "" {
+		headers = append(headers, header{name: "Authorization", value: fmt.Sprintf("Bearer %s", *githubToken)})
+	}
+	return headers
+}
+
+// latestReleaseAsset finds the asset matching the given pattern in the latest release of the given repository. The
+// pattern may contain {{version}} (the tag name with any leading "v" removed) and {{tag}} placeholders, and glob
+// wildcards as supported by path.Match. The asset's checksum is read from the asset matching checksumsPattern if it
+// is non-empty; otherwise it is taken from the API if available, or by downloading and hashing the asset.
+func latestReleaseAsset(ctx context.Context, repo, assetPattern, checksumsPattern string) (map[string]string, error) {
+	api, headers, err := releaseAPI(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var release forgeRelease
+	if err := getJSON(ctx, api, &release, headers...); err != nil {
+		return nil, err
+	}
+
+	version := strings.TrimPrefix(release.TagName, "v")
+	placeholders := strings.NewReplacer("{{version}}", version, "{{tag}}", release.TagName)
+
+	findAsset := func(pattern string) (int, error) {
+		pattern = placeholders.Replace(pattern)
+		for i := range release.Assets {
+			if ok, err := path.Match(pattern, release.Assets[i].Name); err != nil {
+				return -1, err
+			} else if ok {
+				return i, nil
+			}
+		}
+		return -1, fmt.Errorf("release %s has no asset matching %s", release.TagName, pattern)
+	}
+
+	asset, err := findAsset(assetPattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var checksum string
+	if checksumsPattern != "" {
+		checksums, err := findAsset(checksumsPattern)
+		if err != nil {
+			return nil, err
+		}
+
+		checksum, err = checksumFromFile(ctx, release.Assets[checksums].DownloadURL, release.Assets[asset].Name, headers...)
+		if err != nil {
+			return nil, err
+		}
+	} else if digest, ok := strings.CutPrefix(release.Assets[asset].Digest, "sha256:"); ok {
+		checksum = digest
+	} else {
+		checksum, err = downloadSha256(ctx, release.Assets[asset].DownloadURL, headers...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return map[string]string{
+		"name":    release.Assets[asset].Name,
+		"sha256":  checksum,
+		"tag":     release.TagName,
+		"url":     release.Assets[asset].DownloadURL,
+		"version": version,
+	}, nil
+}
+
+// checksumFromFile downloads a checksums file in the format used by sha256sum, and returns the checksum of the named
+// file.
+func checksumFromFile(ctx context.Context, url, name string, headers ...header) (string, error) {
+	body, err := httpGet(ctx, url, headers...)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+
+		if decoded, err := hex.DecodeString(fields[0]); err != nil || len(decoded) != 32 {
+			return "", fmt.Errorf("checksums file %s has an invalid sha256 checksum for %s", url, name)
+		}
+		return strings.ToLower(fields[0]), nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("checksums file %s has no checksum for %s", url, name)
+}
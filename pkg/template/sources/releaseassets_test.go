@@ -0,0 +1,133 @@
+package sources
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatestReleaseAsset(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/cli/cli/releases/latest":
+			_, _ = w.Write([]byte(`{"tag_name": "v2.40.0", "assets": [
+				{"name": "gh_2.40.0_checksums.txt", "browser_download_url": "` + server.URL + `/checksums.txt"},
+				{"name": "gh_2.40.0_linux_arm64.tar.gz", "browser_download_url": "` + server.URL + `/arm64.tar.gz"},
+				{"name": "gh_2.40.0_linux_amd64.tar.gz", "browser_download_url": "` + server.URL + `/amd64.tar.gz", "digest": "sha256:0123"}
+			]}`))
+		case "/checksums.txt":
+			_, _ = w.Write([]byte("aaaa000000000000000000000000000000000000000000000000000000000000  gh_2.40.0_linux_arm64.tar.gz\n" +
+				"BBBB000000000000000000000000000000000000000000000000000000000000 *gh_2.40.0_linux_amd64.tar.gz\n"))
+		case "/api/v1/repos/forgejo/runner/releases/latest":
+			_, _ = w.Write([]byte(`{"tag_name": "3.3.0", "assets": [
+				{"name": "forgejo-runner-3.3.0-linux-amd64", "browser_download_url": "` + server.URL + `/runner"}
+			]}`))
+		case "/arm64.tar.gz":
+			_, _ = w.Write([]byte("not a checksums file"))
+		case "/runner":
+			_, _ = w.Write([]byte("hello"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	original := *githubAPIURL
+	*githubAPIURL = server.URL
+	t.Cleanup(func() { *githubAPIURL = original })
+
+	tests := []struct {
+		name             string
+		repo             string
+		assetPattern     string
+		checksumsPattern string
+		want             map[string]string
+		wantErr          string
+	}{
+		{
+			name:             "checksums file",
+			repo:             "cli/cli",
+			assetPattern:     "gh_{{version}}_linux_amd64.tar.gz",
+			checksumsPattern: "gh_{{version}}_checksums.txt",
+			want: map[string]string{
+				"name":    "gh_2.40.0_linux_amd64.tar.gz",
+				"sha256":  "bbbb000000000000000000000000000000000000000000000000000000000000",
+				"tag":     "v2.40.0",
+				"url":     server.URL + "/amd64.tar.gz",
+				"version": "2.40.0",
+			},
+		},
+		{
+			name:         "digest from API",
+			repo:         "https://github.com/cli/cli",
+			assetPattern: "gh_*_linux_amd64.tar.gz",
+			want: map[string]string{
+				"name":    "gh_2.40.0_linux_amd64.tar.gz",
+				"sha256":  "0123",
+				"tag":     "v2.40.0",
+				"url":     server.URL + "/amd64.tar.gz",
+				"version": "2.40.0",
+			},
+		},
+		{
+			name:         "gitea api and hashing the asset",
+			repo:         server.URL + "/forgejo/runner",
+			assetPattern: "forgejo-runner-{{tag}}-linux-amd64",
+			want: map[string]string{
+				"name":    "forgejo-runner-3.3.0-linux-amd64",
+				"sha256":  "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+				"tag":     "3.3.0",
+				"url":     server.URL + "/runner",
+				"version": "3.3.0",
+			},
+		},
+		{
+			name:         "missing asset",
+			repo:         "cli/cli",
+			assetPattern: "gh_{{version}}_windows_amd64.zip",
+			wantErr:      "release v2.40.0 has no asset matching gh_2.40.0_windows_amd64.zip",
+		},
+		{
+			name:             "missing checksum",
+			repo:             "cli/cli",
+			assetPattern:     "gh_{{version}}_linux_amd64.tar.gz",
+			checksumsPattern: "gh_{{version}}_linux_arm64.tar.gz",
+			wantErr:          "checksums file " + server.URL + "/arm64.tar.gz has no checksum for gh_2.40.0_linux_amd64.tar.gz",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := latestReleaseAsset(t.Context(), tt.repo, tt.assetPattern, tt.checksumsPattern)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReleaseAssetCacheKey(t *testing.T) {
+	original := *githubAPIURL
+	t.Cleanup(func() { *githubAPIURL = original })
+
+	*githubAPIURL = "https://api.github.com/"
+	public, err := releaseAssetCacheKey("csmith/contempt", "contempt_{{version}}.tar.gz", "checksums.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "https://api.github.com/repos/csmith/contempt/releases/latest|contempt_{{version}}.tar.gz|checksums.txt", public)
+
+	*githubAPIURL = "https://github.example.com/api/v3/"
+	enterprise, err := releaseAssetCacheKey("csmith/contempt", "contempt_{{version}}.tar.gz", "checksums.txt")
+	require.NoError(t, err)
+	assert.NotEqual(t, public, enterprise)
+
+	forgejo, err := releaseAssetCacheKey("https://codeberg.org/csmith/contempt", "contempt_{{version}}.tar.gz", "")
+	require.NoError(t, err)
+	assert.Equal(t, "https://codeberg.org/api/v1/repos/csmith/contempt/releases/latest|contempt_{{version}}.tar.gz|", forgejo)
+}
@@ -10,87 +10,112 @@ import (
 	"github.com/csmith/latest/v3"
 )
 
+// release holds the details of a release that has been looked up.
+type release struct {
+	Version  string `json:"version"`
+	URL      string `json:"url"`
+	Checksum string `json:"checksum"`
+}
+
+// onceRelease looks up a release the first time it is needed, and then returns the same result for all subsequent
+// calls. Failed lookups are not cached, and will be retried on the next call. It is safe for concurrent use.
+type onceRelease struct {
+	releases lookupGroup[release]
+	name     string
+	lookup   func(ctx context.Context) (string, string, string, error)
+}
+
+func (o *onceRelease) get(ctx context.Context) (release, error) {
+	return o.releases.get(ctx, o.name, func(ctx context.Context) (release, error) {
+		return cached("release", o.name, func() (release, error) {
+			version, url, checksum, err := o.lookup(ctx)
+			return release{Version: version, URL: url, Checksum: checksum}, err
+		})
+	})
+}
+
 func AlpineReleaseSource(mirror string) template.FunctionSource {
-	return func(writer template.BomWriter) tt.FuncMap {
-		var version, url, checksum string
-		once := sync.Once{}
-		check := func() error {
-			var err error
-			once.Do(func() {
-				version, url, checksum, err = latest.AlpineRelease(context.Background(), &latest.AlpineReleaseOptions{
-					Mirror:  mirror,
-					Flavour: "minirootfs",
-				})
+	alpine := &onceRelease{
+		name: fmt.Sprintf("alpine|%s", mirror),
+		lookup: func(ctx context.Context) (string, string, string, error) {
+			return latest.AlpineRelease(ctx, &latest.AlpineReleaseOptions{
+				Mirror:  mirror,
+				Flavour: "minirootfs",
 			})
-			return err
-		}
+		},
+	}
 
+	return func(writer template.BomWriter) tt.FuncMap {
 		return tt.FuncMap{
 			"alpine_url": func() (string, error) {
-				if err := check(); err != nil {
+				r, err := alpine.get(template.ContextOf(writer))
+				if err != nil {
 					return "", err
 				}
 
-				writer.Write("alpine", version)
-				return url, nil
+				writer.Write("alpine", r.Version)
+				return r.URL, nil
 			},
 			"alpine_checksum": func() (string, error) {
-				if err := check(); err != nil {
+				r, err := alpine.get(template.ContextOf(writer))
+				if err != nil {
 					return "", err
 				}
 
-				writer.Write("alpine", version)
-				return checksum, nil
+				writer.Write("alpine", r.Version)
+				return r.Checksum, nil
 			},
 		}
 	}
 }
 
 func GoReleaseSource() template.FunctionSource {
-	return func(writer template.BomWriter) tt.FuncMap {
-
-		var version, url, checksum string
-		once := sync.Once{}
-		check := func() error {
-			var err error
-			once.Do(func() {
-				version, url, checksum, err = latest.GoRelease(context.Background(), nil)
-			})
-			return err
-		}
+	golang := &onceRelease{
+		name: "golang",
+		lookup: func(ctx context.Context) (string, string, string, error) {
+			return latest.GoRelease(ctx, nil)
+		},
+	}
 
+	return func(writer template.BomWriter) tt.FuncMap {
 		return tt.FuncMap{
 			"golang_url": func() (string, error) {
-				if err := check(); err != nil {
+				r, err := golang.get(template.ContextOf(writer))
+				if err != nil {
 					return "", err
 				}
 
-				writer.Write("golang", version)
-				return url, nil
+				writer.Write("golang", r.Version)
+				return r.URL, nil
 			},
 			"golang_checksum": func() (string, error) {
-				if err := check(); err != nil {
+				r, err := golang.get(template.ContextOf(writer))
+				if err != nil {
 					return "", err
 				}
 
-				writer.Write("golang", version)
-				return checksum, nil
+				writer.Write("golang", r.Version)
+				return r.Checksum, nil
 			},
 		}
 	}
 }
 
 func PostgresReleaseSource() template.FunctionSource {
+	postgres := &postgresReleases{
+		releases: make(map[int]*onceRelease),
+	}
+
 	return func(writer template.BomWriter) tt.FuncMap {
 		var res = make(tt.FuncMap)
 
-		dynamic := postgresDynamicReleaseFuncs(writer)
+		dynamic := postgresDynamicReleaseFuncs(writer, postgres)
 		for k := range dynamic {
 			res[k] = dynamic[k]
 		}
 
 		for i := 13; i <= 17; i++ {
-			specific := postgresSpecificReleaseFuncs(writer, i)
+			specific := postgresSpecificReleaseFuncs(writer, postgres, i)
 			for k := range specific {
 				res[k] = specific[k]
 			}
@@ -100,84 +125,75 @@ func PostgresReleaseSource() template.FunctionSource {
 	}
 }
 
-func postgresSpecificReleaseFuncs(writer template.BomWriter, majorVersion int) tt.FuncMap {
-	var version, url, checksum string
-	once := sync.Once{}
-	check := func() error {
-		var err error
-		once.Do(func() {
-			version, url, checksum, err = latest.PostgresRelease(context.Background(), &latest.TagOptions{
-				MajorVersionMax: majorVersion,
-			})
-		})
-		return err
+// postgresReleases looks up and caches the latest release of each major version of postgres.
+type postgresReleases struct {
+	mutex    sync.Mutex
+	releases map[int]*onceRelease
+}
+
+func (p *postgresReleases) get(ctx context.Context, majorVersion int) (release, error) {
+	p.mutex.Lock()
+	r, ok := p.releases[majorVersion]
+	if !ok {
+		r = &onceRelease{
+			name: fmt.Sprintf("postgres|%d", majorVersion),
+			lookup: func(ctx context.Context) (string, string, string, error) {
+				return latest.PostgresRelease(ctx, &latest.TagOptions{
+					MajorVersionMax: majorVersion,
+				})
+			},
+		}
+		p.releases[majorVersion] = r
 	}
+	p.mutex.Unlock()
+
+	return r.get(ctx)
+}
 
+func postgresSpecificReleaseFuncs(writer template.BomWriter, postgres *postgresReleases, majorVersion int) tt.FuncMap {
 	return tt.FuncMap{
 		fmt.Sprintf("postgres%d_url", majorVersion): func() (string, error) {
-			if err := check(); err != nil {
+			r, err := postgres.get(template.ContextOf(writer), majorVersion)
+			if err != nil {
 				return "", err
 			}
 
-			writer.Write(fmt.Sprintf("postgres%d", majorVersion), version)
-			return url, nil
+			writer.Write(fmt.Sprintf("postgres%d", majorVersion), r.Version)
+			return r.URL, nil
 		},
 
 		fmt.Sprintf("postgres%d_checksum", majorVersion): func() (string, error) {
-			if err := check(); err != nil {
+			r, err := postgres.get(template.ContextOf(writer), majorVersion)
+			if err != nil {
 				return "", err
 			}
 
-			writer.Write(fmt.Sprintf("postgres%d", majorVersion), version)
-			return checksum, nil
+			writer.Write(fmt.Sprintf("postgres%d", majorVersion), r.Version)
+			return r.Checksum, nil
 		},
 	}
 }
 
-func postgresDynamicReleaseFuncs(writer template.BomWriter) tt.FuncMap {
-	type details struct {
-		version  string
-		url      string
-		checksum string
-	}
-	var cache = make(map[int]details)
-	query := func(majorVersion int) (*details, error) {
-		if cache[majorVersion].version == "" {
-			version, url, checksum, err := latest.PostgresRelease(context.Background(), &latest.TagOptions{
-				MajorVersionMax: majorVersion,
-			})
-			if err != nil {
-				return nil, err
-			}
-			cache[majorVersion] = details{
-				version:  version,
-				url:      url,
-				checksum: checksum,
-			}
-		}
-		d := cache[majorVersion]
-		return &d, nil
-	}
-
+func postgresDynamicReleaseFuncs(writer template.BomWriter, postgres *postgresReleases) tt.FuncMap {
 	return tt.FuncMap{
 		"postgres_url": func(version int) (string, error) {
-			d, err := query(version)
+			r, err := postgres.get(template.ContextOf(writer), version)
 			if err != nil {
 				return "", err
 			}
 
-			writer.Write(fmt.Sprintf("postgres%d", version), d.version)
-			return d.url, nil
+			writer.Write(fmt.Sprintf("postgres%d", version), r.Version)
+			return r.URL, nil
 		},
 
 		"postgres_checksum": func(version int) (string, error) {
-			d, err := query(version)
+			r, err := postgres.get(template.ContextOf(writer), version)
 			if err != nil {
 				return "", err
 			}
 
-			writer.Write(fmt.Sprintf("postgres%d", version), d.version)
-			return d.checksum, nil
+			writer.Write(fmt.Sprintf("postgres%d", version), r.Version)
+			return r.Checksum, nil
 		},
 	}
 }
@@ -0,0 +1,335 @@
+package sources
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	tt "text/template"
+
+	"github.com/csmith/contempt/pkg/template"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	rpmRepositories = flag.String("rpm-repositories", "https://dl.rockylinux.org/pub/rocky/9/BaseOS/x86_64/os/,https://dl.rockylinux.org/pub/rocky/9/AppStream/x86_64/os/", "Comma-separated list of base URLs of RPM repositories to query package info from")
+	rpmArchitecture = flag.String("rpm-architecture", "x86_64", "Architecture to query RPM package info for (noarch packages are always included)")
+)
+
+func RpmPackagesSource() template.FunctionSource {
+	return func(writer template.BomWriter) tt.FuncMap {
+		return tt.FuncMap{
+			"rpm_packages": func(packages ...string) (map[string]string, error) {
+				repos := strings.Split(*rpmRepositories, ",")
+				res, err := cached("rpm_packages", fmt.Sprintf("%s|%s|%s", *rpmRepositories, *rpmArchitecture, strings.Join(packages, ",")), func() (map[string]string, error) {
+					return latestRpmPackages(template.ContextOf(writer), repos, *rpmArchitecture, packages...)
+				})
+				if err != nil {
+					return nil, err
+				}
+				for i := range res {
+					writer.Write(fmt.Sprintf("rpm:%s", i), res[i])
+				}
+				return res, nil
+			},
+		}
+	}
+}
+
+// latestRpmPackages returns a map of packages to their latest version (in [epoch:]version-release form). The result
+// will include all the provided package names (or a package that provides them), plus all of their direct and
+// transitive dependencies.
+func latestRpmPackages(ctx context.Context, repos []string, arch string, names ...string) (map[string]string, error) {
+	index, err := rpmPackageInfos(ctx, repos, arch)
+	if err != nil {
+		return nil, err
+	}
+
+	return index.resolve(names...)
+}
+
+var rpmPackageCache lookupGroup[*packageIndex]
+
+// rpmPackageInfos returns the index of all packages in the given repositories. The returned index must not be
+// modified.
+func rpmPackageInfos(ctx context.Context, repos []string, arch string) (*packageIndex, error) {
+	key := fmt.Sprintf("%s|%s", strings.Join(repos, ","), arch)
+	return rpmPackageCache.get(ctx, key, func(ctx context.Context) (*packageIndex, error) {
+		index := newPackageIndex(compareRpmVersions)
+		for _, repo := range repos {
+			if err := downloadRpmPackages(ctx, index, strings.TrimSpace(repo), arch); err != nil {
+				return nil, err
+			}
+		}
+
+		index.finish()
+		return index, nil
+	})
+}
+
+// repomd is the subset of a repository's repodata/repomd.xml file that we care about.
+type repomd struct {
+	Data []struct {
+		Type     string `xml:"type,attr"`
+		Location struct {
+			Href string `xml:"href,attr"`
+		} `xml:"location"`
+	} `xml:"data"`
+}
+
+// downloadRpmPackages finds the primary metadata of the repository at the given base URL, and adds all of its
+// packages for the given architecture to the index.
+func downloadRpmPackages(ctx context.Context, index *packageIndex, repo, arch string) error {
+	base, err := url.Parse(strings.TrimSuffix(repo, "/") + "/")
+	if err != nil {
+		return fmt.Errorf("invalid RPM repository %s: %v", repo, err)
+	}
+
+	body, err := httpGet(ctx, base.JoinPath("repodata", "repomd.xml").String())
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	var metadata repomd
+	if err := xml.NewDecoder(body).Decode(&metadata); err != nil {
+		return fmt.Errorf("unable to parse repository metadata for %s: %v", repo, err)
+	}
+
+	for _, data := range metadata.Data {
+		if data.Type == "primary" {
+			location, err := base.Parse(data.Location.Href)
+			if err != nil {
+				return fmt.Errorf("invalid primary metadata location for %s: %v", repo, err)
+			}
+			return downloadRpmPrimary(ctx, index, location.String(), arch)
+		}
+	}
+
+	return fmt.Errorf("repository %s has no primary metadata", repo)
+}
+
+// downloadRpmPrimary retrieves the (possibly compressed) primary metadata at the given URL, and adds all of its
+// packages for the given architecture to the index.
+func downloadRpmPrimary(ctx context.Context, index *packageIndex, location, arch string) error {
+	body, err := httpGet(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	var reader io.Reader
+	switch path.Ext(location) {
+	case ".gz":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return fmt.Errorf("unable to decompress primary metadata %s: %v", location, err)
+		}
+		defer gz.Close()
+		reader = gz
+	case ".zst":
+		zst, err := zstd.NewReader(body)
+		if err != nil {
+			return fmt.Errorf("unable to decompress primary metadata %s: %v", location, err)
+		}
+		defer zst.Close()
+		reader = zst
+	case ".xml":
+		reader = body
+	default:
+		return fmt.Errorf("primary metadata %s uses an unsupported compression format", location)
+	}
+
+	if err := parseRpmPrimary(index, reader, arch); err != nil {
+		return fmt.Errorf("unable to parse primary metadata %s: %v", location, err)
+	}
+	return nil
+}
+
+// rpmPrimaryPackage is the subset of a package in a repository's primary metadata that we care about.
+type rpmPrimaryPackage struct {
+	Name    string `xml:"name"`
+	Arch    string `xml:"arch"`
+	Version struct {
+		Epoch   string `xml:"epoch,attr"`
+		Version string `xml:"ver,attr"`
+		Release string `xml:"rel,attr"`
+	} `xml:"version"`
+	Provides []rpmEntry `xml:"format>provides>entry"`
+	Requires []rpmEntry `xml:"format>requires>entry"`
+	Files    []string   `xml:"format>file"`
+}
+
+type rpmEntry struct {
+	Name string `xml:"name,attr"`
+}
+
+// parseRpmPrimary reads primary metadata and adds all packages for the given architecture (or noarch) to the index.
+// Files listed in the metadata are treated as being provided by their package, so that requirements on paths such
+// as /bin/sh can be resolved.
+func parseRpmPrimary(index *packageIndex, r io.Reader, arch string) error {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "package" {
+			continue
+		}
+
+		var p rpmPrimaryPackage
+		if err := decoder.DecodeElement(&p, &start); err != nil {
+			return err
+		}
+
+		if p.Arch != arch && p.Arch != "noarch" {
+			continue
+		}
+
+		info := &packageInfo{
+			Name:    p.Name,
+			Version: formatRpmVersion(p.Version.Epoch, p.Version.Version, p.Version.Release),
+		}
+		for _, provides := range p.Provides {
+			info.Provides = append(info.Provides, provides.Name)
+		}
+		info.Provides = append(info.Provides, p.Files...)
+		for _, requires := range p.Requires {
+			// Requirements on rpmlib features are satisfied by rpm itself, and rich dependencies (such as
+			// "(foo if bar)") can't be resolved without knowing what else is installed.
+			if !strings.HasPrefix(requires.Name, "rpmlib(") && !strings.HasPrefix(requires.Name, "(") {
+				info.Dependencies = append(info.Dependencies, []string{requires.Name})
+			}
+		}
+		index.add(info)
+	}
+}
+
+// formatRpmVersion formats the version of a package as [epoch:]version-release, omitting the epoch if it is zero.
+func formatRpmVersion(epoch, version, release string) string {
+	evr := fmt.Sprintf("%s-%s", version, release)
+	if epoch != "" && epoch != "0" {
+		evr = fmt.Sprintf("%s:%s", epoch, evr)
+	}
+	return evr
+}
+
+// compareRpmVersions compares two [epoch:]version-release strings according to the rules used by rpm, returning a
+// negative number if a is older than b, a positive number if a is newer than b, and zero if they are equal.
+func compareRpmVersions(a, b string) int {
+	aEpoch, aRest := splitEpoch(a)
+	bEpoch, bRest := splitEpoch(b)
+	if c := compareNumbers(aEpoch, bEpoch); c != 0 {
+		return c
+	}
+
+	aVersion, aRelease := splitRevision(aRest)
+	bVersion, bRelease := splitRevision(bRest)
+	if c := rpmvercmp(aVersion, bVersion); c != 0 {
+		return c
+	}
+	return rpmvercmp(aRelease, bRelease)
+}
+
+// rpmvercmp compares a version or release string in the same way as rpm's function of the same name: each string is
+// split into alternating alphabetic and numeric segments, which are compared in turn. Numeric segments are newer than
+// alphabetic ones, a tilde sorts before everything and a caret sorts after the end of the string.
+func rpmvercmp(a, b string) int {
+	isAlnum := func(c byte) bool {
+		return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+	}
+
+	for a != "" || b != "" {
+		a = strings.TrimLeftFunc(a, func(r rune) bool { return r < 128 && !isAlnum(byte(r)) && r != '~' && r != '^' })
+		b = strings.TrimLeftFunc(b, func(r rune) bool { return r < 128 && !isAlnum(byte(r)) && r != '~' && r != '^' })
+
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			if a == "" {
+				return -1
+			}
+			if b == "" {
+				return 1
+			}
+			if !strings.HasPrefix(a, "^") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "^") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		if a == "" || b == "" {
+			break
+		}
+
+		numeric := a[0] >= '0' && a[0] <= '9'
+		var aSegment, bSegment string
+		if numeric {
+			aSegment, a = splitDigits(a, true)
+			bSegment, b = splitDigits(b, true)
+		} else {
+			aSegment, a = splitRpmAlpha(a)
+			bSegment, b = splitRpmAlpha(b)
+		}
+
+		if bSegment == "" {
+			// Segments of different types; numeric segments are always newer.
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+
+		var c int
+		if numeric {
+			c = compareNumbers(aSegment, bSegment)
+		} else {
+			c = strings.Compare(aSegment, bSegment)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+// splitRpmAlpha splits the given string after its leading run of letters.
+func splitRpmAlpha(s string) (string, string) {
+	i := 0
+	for i < len(s) && ((s[i] >= 'a' && s[i] <= 'z') || (s[i] >= 'A' && s[i] <= 'Z')) {
+		i++
+	}
+	return s[:i], s[i:]
+}
@@ -0,0 +1,176 @@
+package sources
+
+import (
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRepomd = `<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo" xmlns:rpm="http://linux.duke.edu/metadata/rpm">
+  <data type="filelists">
+    <location href="repodata/abc-filelists.xml.gz"/>
+  </data>
+  <data type="primary">
+    <location href="repodata/def-primary.xml.gz"/>
+  </data>
+</repomd>
+`
+
+const testPrimary = `<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm" packages="6">
+<package type="rpm">
+  <name>curl</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="7.76.1" rel="29.el9"/>
+  <format>
+    <rpm:provides>
+      <rpm:entry name="curl" flags="EQ" epoch="0" ver="7.76.1" rel="29.el9"/>
+    </rpm:provides>
+    <rpm:requires>
+      <rpm:entry name="libcurl(x86-64)" flags="GE" epoch="0" ver="7.76.1"/>
+      <rpm:entry name="/bin/sh" pre="1"/>
+      <rpm:entry name="rpmlib(CompressedFileNames)" flags="LE" epoch="0" ver="3.0.4" rel="1"/>
+      <rpm:entry name="(curl-minimal if systemd)"/>
+    </rpm:requires>
+    <file>/usr/bin/curl</file>
+  </format>
+</package>
+<package type="rpm">
+  <name>curl</name>
+  <arch>i686</arch>
+  <version epoch="0" ver="7.99.0" rel="1.el9"/>
+</package>
+<package type="rpm">
+  <name>libcurl</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="7.76.1" rel="26.el9"/>
+  <format>
+    <rpm:provides>
+      <rpm:entry name="libcurl(x86-64)"/>
+    </rpm:provides>
+  </format>
+</package>
+<package type="rpm">
+  <name>libcurl</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="7.76.1" rel="29.el9"/>
+  <format>
+    <rpm:provides>
+      <rpm:entry name="libcurl(x86-64)"/>
+    </rpm:provides>
+    <rpm:requires>
+      <rpm:entry name="ca-certificates"/>
+    </rpm:requires>
+  </format>
+</package>
+<package type="rpm">
+  <name>ca-certificates</name>
+  <arch>noarch</arch>
+  <version epoch="0" ver="2024.2.69_v8.0.303" rel="91.4.el9"/>
+</package>
+<package type="rpm">
+  <name>bash</name>
+  <arch>x86_64</arch>
+  <version epoch="1" ver="5.1.8" rel="9.el9"/>
+  <format>
+    <file>/bin/sh</file>
+    <file>/usr/bin/bash</file>
+  </format>
+</package>
+</metadata>
+`
+
+func TestLatestRpmPackages(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "repodata"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "repodata", "repomd.xml"), []byte(testRepomd), 0644))
+
+	primary, err := os.Create(filepath.Join(dir, "repodata", "def-primary.xml.gz"))
+	require.NoError(t, err)
+	writer := gzip.NewWriter(primary)
+	_, err = writer.Write([]byte(testPrimary))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	require.NoError(t, primary.Close())
+
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		packages []string
+		want     map[string]string
+		wantErr  string
+	}{
+		{
+			name:     "package with no dependencies",
+			packages: []string{"ca-certificates"},
+			want:     map[string]string{"ca-certificates": "2024.2.69_v8.0.303-91.4.el9"},
+		},
+		{
+			name:     "transitive dependencies, provides and files",
+			packages: []string{"curl"},
+			want: map[string]string{
+				"bash":            "1:5.1.8-9.el9",
+				"ca-certificates": "2024.2.69_v8.0.303-91.4.el9",
+				"curl":            "7.76.1-29.el9",
+				"libcurl":         "7.76.1-29.el9",
+			},
+		},
+		{
+			name:     "missing package",
+			packages: []string{"wget"},
+			wantErr:  "package required but not found: wget",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := latestRpmPackages(t.Context(), []string{server.URL}, "x86_64", tt.packages...)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCompareRpmVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0-1", "1.0-1", 0},
+		{"1.0-1", "1.0-2", -1},
+		{"1.10-1", "1.9-1", 1},
+		{"1:1.0-1", "2.0-1", 1},
+		{"1.0~rc1-1", "1.0-1", -1},
+		{"1.0^git1-1", "1.0-1", 1},
+		{"1.0^git1-1", "1.0.1-1", -1},
+		{"1.0a-1", "1.0.1-1", -1},
+		{"7.76.1-26.el9", "7.76.1-29.el9", -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"_"+tt.b, func(t *testing.T) {
+			got := compareRpmVersions(tt.a, tt.b)
+			switch {
+			case tt.want < 0:
+				assert.Negative(t, got)
+			case tt.want > 0:
+				assert.Positive(t, got)
+			default:
+				assert.Zero(t, got)
+			}
+		})
+	}
+}
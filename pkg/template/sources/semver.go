@@ -0,0 +1,205 @@
+package sources
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+// goPseudoVersion matches Go module pseudo-versions, such as "v0.0.0-20190101000000-abcdefabcdef", which refer to
+// untagged commits.
+var goPseudoVersion = regexp.MustCompile(`^v[0-9]+\.(0\.0-|\d+\.\d+-([^+]*\.)?0\.)\d{14}-[A-Za-z0-9]+(\+[0-9A-Za-z-]+)?$`)
+
+// latestSemver returns the highest of the given versions. Versions that can't be parsed are ignored, as are
+// pre-release versions unless preRelease is true. Go pseudo-versions aren't treated as pre-releases, as they're only
+// given for modules without any tagged versions.
+func latestSemver(versions []string, preRelease bool) (string, error) {
+	return latestSemverFunc(versions, func(v *version.Version) bool {
+		return preRelease || v.Prerelease() == "" || goPseudoVersion.MatchString(v.Original())
+	})
+}
+
+// latestSemverMatching returns the highest of the given versions that satisfies the constraint (see
+// parseVersionConstraint). Versions that can't be parsed are ignored, as are pre-release versions unless the
+// constraint explicitly mentions a pre-release.
+func latestSemverMatching(versions []string, constraint string) (string, error) {
+	c, err := parseVersionConstraint(constraint)
+	if err != nil {
+		return "", err
+	}
+	return latestSemverFunc(versions, c.check)
+}
+
+// latestSemverFunc returns the highest of the given versions that is accepted by the filter. Versions that can't be
+// parsed are ignored.
+func latestSemverFunc(versions []string, filter func(*version.Version) bool) (string, error) {
+	var best *version.Version
+	var bestRaw string
+	for _, raw := range versions {
+		v, err := version.NewVersion(raw)
+		if err != nil || !filter(v) {
+			continue
+		}
+		// Prefer the most specific form of equal versions, e.g. "1.4.0" over "1.4".
+		if best == nil || v.GreaterThan(best) || (v.Equal(best) && len(raw) > len(bestRaw)) {
+			best = v
+			bestRaw = raw
+		}
+	}
+
+	if best == nil {
+		return "", errors.New("no matching versions found")
+	}
+	return bestRaw, nil
+}
+
+// versionConstraint is a set of alternative constraints, any one of which must be satisfied.
+type versionConstraint []version.Constraints
+
+// check determines whether the version satisfies the constraint. Pre-release versions only satisfy alternatives
+// that mention a pre-release.
+func (c versionConstraint) check(v *version.Version) bool {
+	for i := range c {
+		if v.Prerelease() != "" && !slices.ContainsFunc(c[i], (*version.Constraint).Prerelease) {
+			continue
+		}
+		if c[i].Check(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseVersionConstraint parses a constraint in the style used by npm and cargo. Alternatives are separated by
+// "||", and each alternative is a list of terms separated by spaces or commas, all of which must be satisfied.
+// Terms may be:
+//
+//   - comparisons such as ">=2.4", "<3" or "!=1.2.3" (and "~>1.4", which behaves as in Ruby)
+//   - tilde ranges such as "~1.4", which allow patch-level changes (>=1.4.0 <1.5.0)
+//   - caret ranges such as "^1.4", which allow changes that don't modify the left-most non-zero segment
+//     (>=1.4.0 <2.0.0)
+//   - wildcards such as "1.x", "1.4.*", "1" or "*"
+//   - exact versions such as "1.4.2"
+func parseVersionConstraint(constraint string) (versionConstraint, error) {
+	var res versionConstraint
+	for _, alternative := range strings.Split(constraint, "||") {
+		var terms []string
+		pendingOperator := ""
+		for _, field := range strings.FieldsFunc(alternative, func(r rune) bool { return r == ' ' || r == ',' }) {
+			if strings.Trim(field, "<>=!~") == "" {
+				// An operator separated from its version by a space, e.g. ">= 2.4".
+				pendingOperator = field
+				continue
+			}
+
+			translated, err := translateConstraintTerm(pendingOperator + field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid version constraint %q: %v", constraint, err)
+			}
+			terms = append(terms, translated...)
+			pendingOperator = ""
+		}
+
+		if pendingOperator != "" {
+			return nil, fmt.Errorf("invalid version constraint %q: operator %s has no version", constraint, pendingOperator)
+		}
+
+		if len(terms) == 0 {
+			terms = []string{">=0.0.0"}
+		}
+
+		c, err := version.NewConstraint(strings.Join(terms, ","))
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint %q: %v", constraint, err)
+		}
+		res = append(res, c)
+	}
+	return res, nil
+}
+
+// translateConstraintTerm converts a single constraint term into one or more constraints understood by go-version.
+func translateConstraintTerm(term string) ([]string, error) {
+	for _, op := range []string{">=", "<=", "!=", "~>", ">", "<", "="} {
+		if strings.HasPrefix(term, op) {
+			return []string{term}, nil
+		}
+	}
+
+	operator := ""
+	if strings.HasPrefix(term, "~") || strings.HasPrefix(term, "^") {
+		operator = term[:1]
+		term = term[1:]
+	}
+
+	base, _, _ := strings.Cut(strings.TrimPrefix(term, "v"), "-")
+	var segments []int
+	for _, part := range strings.Split(base, ".") {
+		if part == "x" || part == "X" || part == "*" {
+			break
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q", term)
+		}
+		segments = append(segments, n)
+	}
+
+	if len(segments) == 0 {
+		if operator != "" {
+			return nil, fmt.Errorf("invalid version %q", term)
+		}
+		return []string{">=0.0.0"}, nil
+	}
+
+	lower := fmt.Sprintf(">=%s", term)
+	if len(segments) < 3 || strings.ContainsAny(term, "xX*") {
+		lower = fmt.Sprintf(">=%s", joinSegments(segments))
+	}
+
+	switch operator {
+	case "~":
+		// Allow changes to the last segment given, or to the patch version if the minor version was given.
+		upper := segments
+		if len(upper) > 2 {
+			upper = upper[:2]
+		}
+		return []string{lower, fmt.Sprintf("<%s", joinSegments(bump(upper)))}, nil
+
+	case "^":
+		// Allow changes to everything after the first non-zero segment.
+		upper := segments
+		for i := range segments {
+			if segments[i] != 0 || i == len(segments)-1 {
+				upper = segments[:i+1]
+				break
+			}
+		}
+		return []string{lower, fmt.Sprintf("<%s", joinSegments(bump(upper)))}, nil
+
+	default:
+		if len(segments) == 3 && !strings.ContainsAny(term, "xX*") {
+			return []string{fmt.Sprintf("=%s", term)}, nil
+		}
+		return []string{lower, fmt.Sprintf("<%s", joinSegments(bump(segments)))}, nil
+	}
+}
+
+// bump increments the last of the given segments.
+func bump(segments []int) []int {
+	res := append([]int{}, segments...)
+	res[len(res)-1]++
+	return res
+}
+
+func joinSegments(segments []int) string {
+	parts := make([]string, len(segments))
+	for i := range segments {
+		parts[i] = strconv.Itoa(segments[i])
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,84 @@
+package sources
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatestSemver(t *testing.T) {
+	tests := []struct {
+		name       string
+		versions   []string
+		preRelease bool
+		want       string
+		wantErr    bool
+	}{
+		{"highest version", []string{"1.2.0", "1.10.0", "1.9.3"}, false, "1.10.0", false},
+		{"keeps prefix", []string{"v0.9.0", "v1.0.0"}, false, "v1.0.0", false},
+		{"ignores pre-releases", []string{"1.0.0", "1.1.0-rc.1", "1.1.0b1"}, false, "1.0.0", false},
+		{"includes pre-releases", []string{"1.0.0", "1.1.0-rc.1"}, true, "1.1.0-rc.1", false},
+		{"ignores invalid versions", []string{"latest", "1.0.0"}, false, "1.0.0", false},
+		{"go pseudo-version", []string{"v0.0.0-20190101000000-abcdefabcdef"}, false, "v0.0.0-20190101000000-abcdefabcdef", false},
+		{"no versions", []string{"1.0.0-alpha"}, false, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := latestSemver(tt.versions, tt.preRelease)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLatestSemverMatching(t *testing.T) {
+	versions := []string{
+		"v0.3.1", "v0.4.0", "v0.4.7", "v1.0.0", "v1.3.9", "v1.4.0", "v1.4.2", "v1.5.0",
+		"v2.3.0", "v2.4.0", "v2.9.1", "v3.0.0-rc.1", "v3.0.0", "v3.1.0-beta.1",
+	}
+
+	tests := []struct {
+		constraint string
+		want       string
+		wantErr    string
+	}{
+		{"", "v3.0.0", ""},
+		{"*", "v3.0.0", ""},
+		{"~1.4", "v1.4.2", ""},
+		{"~1", "v1.5.0", ""},
+		{"~1.4.1", "v1.4.2", ""},
+		{"^1.3", "v1.5.0", ""},
+		{"^0.4", "v0.4.7", ""},
+		{"1.x", "v1.5.0", ""},
+		{"1.4.*", "v1.4.2", ""},
+		{"2", "v2.9.1", ""},
+		{"v1.4.0", "v1.4.0", ""},
+		{">=2.4 <3", "v2.9.1", ""},
+		{">= 2.4, < 3", "v2.9.1", ""},
+		{"<2 || >=2.4 <2.5", "v2.4.0", ""},
+		{"~> 1.3", "v1.5.0", ""},
+		{"!=3.0.0", "v2.9.1", ""},
+		{"3.0.0-rc.1", "v3.0.0-rc.1", ""},
+		{"~4", "", "no matching versions found"},
+		{"~banana", "", `invalid version constraint "~banana": invalid version "banana"`},
+		{">=", "", `invalid version constraint ">=": operator >= has no version`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.constraint, func(t *testing.T) {
+			got, err := latestSemverMatching(versions, tt.constraint)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
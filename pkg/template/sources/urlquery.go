@@ -0,0 +1,304 @@
+package sources
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+var urlHeadersFile = flag.String("url-headers", "", "Path to a YAML file of headers to send when querying URLs with json_url_content and yaml_url_content, keyed by URL prefix")
+
+// queryResult is the result of querying a document. It is either a single scalar value, or a list of them.
+type queryResult struct {
+	Values []string `json:"values"`
+	List   bool     `json:"list"`
+}
+
+// value returns the result in the form returned to templates: a string for scalars, or a []string for lists.
+func (q queryResult) value() any {
+	if q.List {
+		return q.Values
+	}
+	return q.Values[0]
+}
+
+// material returns the result in the form recorded in the BOM.
+func (q queryResult) material() string {
+	return strings.Join(q.Values, ", ")
+}
+
+// queryURLContent retrieves the document at the given URL, decodes it using the given function, and then evaluates
+// the query against it.
+func queryURLContent(ctx context.Context, url, query string, decode func([]byte) (any, error)) (queryResult, error) {
+	headers, err := urlHeaders(url)
+	if err != nil {
+		return queryResult{}, err
+	}
+
+	body, err := httpGet(ctx, url, headers...)
+	if err != nil {
+		return queryResult{}, err
+	}
+	defer body.Close()
+
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return queryResult{}, err
+	}
+
+	document, err := decode(content)
+	if err != nil {
+		return queryResult{}, fmt.Errorf("unable to parse %s: %v", url, err)
+	}
+
+	return queryDocument(document, query)
+}
+
+// urlQueryCacheKey returns the key that the result of querying the given URL is cached under. If any headers from the
+// -url-headers file apply to the URL, a hash of them is included, so that results retrieved with different
+// credentials are cached separately.
+func urlQueryCacheKey(url, query string, headers []header) string {
+	if len(headers) == 0 {
+		return fmt.Sprintf("%s|%s", url, query)
+	}
+
+	hash := sha256.New()
+	for i := range headers {
+		_, _ = fmt.Fprintf(hash, "%s: %s\n", headers[i].name, headers[i].value)
+	}
+	return fmt.Sprintf("%s|%s|%s", url, query, hex.EncodeToString(hash.Sum(nil)))
+}
+
+func decodeJSON(content []byte) (any, error) {
+	var document any
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	return document, decoder.Decode(&document)
+}
+
+func decodeYAML(content []byte) (any, error) {
+	var document any
+	return document, yaml.Unmarshal(content, &document)
+}
+
+var (
+	urlHeadersOnce   sync.Once
+	urlHeadersConfig map[string]map[string]string
+	urlHeadersErr    error
+)
+
+// urlHeaders returns the headers configured by the -url-headers file for the given URL. Headers from every prefix
+// that matches the URL are included, with longer prefixes taking precedence. Environment variables in header values
+// (such as "Bearer ${API_TOKEN}") are expanded.
+func urlHeaders(url string) ([]header, error) {
+	urlHeadersOnce.Do(func() {
+		if *urlHeadersFile == "" {
+			return
+		}
+
+		content, err := os.ReadFile(*urlHeadersFile)
+		if err != nil {
+			urlHeadersErr = fmt.Errorf("unable to read URL headers: %v", err)
+			return
+		}
+
+		if err := yaml.Unmarshal(content, &urlHeadersConfig); err != nil {
+			urlHeadersErr = fmt.Errorf("unable to parse URL headers: %v", err)
+		}
+	})
+
+	if urlHeadersErr != nil {
+		return nil, urlHeadersErr
+	}
+
+	return matchURLHeaders(urlHeadersConfig, url), nil
+}
+
+// matchURLHeaders returns the headers from the given config (a map of URL prefixes to headers) that apply to the URL.
+func matchURLHeaders(config map[string]map[string]string, url string) []header {
+	matched := make(map[string]string)
+	matchedLength := make(map[string]int)
+	for prefix, headers := range config {
+		if !strings.HasPrefix(url, prefix) {
+			continue
+		}
+		for name, value := range headers {
+			name = strings.ToLower(name)
+			if len(prefix) >= matchedLength[name] {
+				matched[name] = os.ExpandEnv(value)
+				matchedLength[name] = len(prefix)
+			}
+		}
+	}
+
+	var res []header
+	for _, name := range slices.Sorted(maps.Keys(matched)) {
+		res = append(res, header{name: name, value: matched[name]})
+	}
+	return res
+}
+
+// queryStep is a single step of a parsed query: a key to look up in an object, an index to look up in an array, or
+// a wildcard that selects every member of an object or array.
+type queryStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parseQuery parses a JSONPath-like query, such as "$.releases[0].version", "items[*].name" or
+// `config["key.with.dots"]`. The leading "$" is optional. Negative indexes count from the end of an array.
+func parseQuery(query string) ([]queryStep, error) {
+	var steps []queryStep
+	rest := strings.TrimPrefix(strings.TrimSpace(query), "$")
+
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid query %q: empty key", query)
+			}
+			if rest[:end] == "*" {
+				steps = append(steps, queryStep{wildcard: true})
+			} else {
+				steps = append(steps, queryStep{key: rest[:end]})
+			}
+			rest = rest[end:]
+
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if strings.HasPrefix(rest, `["`) || strings.HasPrefix(rest, `['`) {
+				end = strings.Index(rest[2:], string(rest[1])+"]")
+				if end != -1 {
+					end += 3
+				}
+			}
+			if end == -1 {
+				return nil, fmt.Errorf("invalid query %q: unterminated [", query)
+			}
+
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			if inner == "*" {
+				steps = append(steps, queryStep{wildcard: true})
+			} else if len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') {
+				steps = append(steps, queryStep{key: inner[1 : len(inner)-1]})
+			} else if index, err := strconv.Atoi(inner); err == nil {
+				steps = append(steps, queryStep{index: index, isIndex: true})
+			} else {
+				return nil, fmt.Errorf("invalid query %q: invalid index %q", query, inner)
+			}
+
+		default:
+			// Allow the first key to be given without a leading dot.
+			if len(steps) > 0 {
+				return nil, fmt.Errorf("invalid query %q: expected . or [ at %q", query, rest)
+			}
+			rest = "." + rest
+		}
+	}
+
+	return steps, nil
+}
+
+// queryDocument evaluates the query against a decoded JSON or YAML document. If the query selects a single scalar,
+// the result is that scalar; if it selects an array, or contains a wildcard, the result is a list of scalars.
+func queryDocument(document any, query string) (queryResult, error) {
+	steps, err := parseQuery(query)
+	if err != nil {
+		return queryResult{}, err
+	}
+
+	nodes := []any{document}
+	list := false
+	for _, step := range steps {
+		var next []any
+		for _, node := range nodes {
+			switch n := node.(type) {
+			case map[string]any:
+				if step.wildcard {
+					for _, key := range slices.Sorted(maps.Keys(n)) {
+						next = append(next, n[key])
+					}
+				} else if v, ok := n[step.key]; ok && !step.isIndex {
+					next = append(next, v)
+				}
+			case []any:
+				if step.wildcard {
+					next = append(next, n...)
+				} else if step.isIndex {
+					index := step.index
+					if index < 0 {
+						index += len(n)
+					}
+					if index >= 0 && index < len(n) {
+						next = append(next, n[index])
+					}
+				}
+			}
+		}
+		list = list || step.wildcard
+		nodes = next
+	}
+
+	if len(nodes) == 0 {
+		return queryResult{}, errors.New("no match found")
+	}
+
+	if array, ok := nodes[0].([]any); ok && !list {
+		nodes = array
+		list = true
+	}
+
+	res := queryResult{List: list}
+	for _, node := range nodes {
+		scalar, err := formatScalar(node)
+		if err != nil {
+			return queryResult{}, err
+		}
+		res.Values = append(res.Values, scalar)
+	}
+
+	if !list && len(res.Values) != 1 {
+		return queryResult{}, errors.New("no match found")
+	}
+	return res, nil
+}
+
+// formatScalar formats a scalar value from a document as a string.
+func formatScalar(node any) (string, error) {
+	switch n := node.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return n, nil
+	case json.Number:
+		return n.String(), nil
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+	case bool, int, int64, uint64:
+		return fmt.Sprint(n), nil
+	default:
+		return "", fmt.Errorf("query selects a %T, not a scalar value", node)
+	}
+}
@@ -0,0 +1,117 @@
+package sources
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testQueryJSON = `{
+	"name": "example",
+	"version": 3,
+	"stable": true,
+	"releases": [{"version": "1.0.0"}, {"version": "1.1.0"}, {"version": "2.0.0-rc1"}],
+	"tags": ["a", "b"],
+	"config": {"key.with.dots": "dotted", "nested": {"value": 1.5}}
+}`
+
+const testQueryYAML = `
+name: example
+releases:
+  - version: 1.0.0
+  - version: 1.1.0
+tags: [a, b]
+`
+
+func TestQueryDocument(t *testing.T) {
+	jsonDocument, err := decodeJSON([]byte(testQueryJSON))
+	require.NoError(t, err)
+	yamlDocument, err := decodeYAML([]byte(testQueryYAML))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		document any
+		query    string
+		want     any
+		wantErr  string
+	}{
+		{"json key", jsonDocument, "name", "example", ""},
+		{"json key with $", jsonDocument, "$.name", "example", ""},
+		{"json number", jsonDocument, "version", "3", ""},
+		{"json bool", jsonDocument, "stable", "true", ""},
+		{"json index", jsonDocument, "releases[1].version", "1.1.0", ""},
+		{"json negative index", jsonDocument, "$.releases[-1].version", "2.0.0-rc1", ""},
+		{"json wildcard", jsonDocument, "releases[*].version", []string{"1.0.0", "1.1.0", "2.0.0-rc1"}, ""},
+		{"json array", jsonDocument, "tags", []string{"a", "b"}, ""},
+		{"json quoted key", jsonDocument, `config["key.with.dots"]`, "dotted", ""},
+		{"json nested", jsonDocument, "config.nested.value", "1.5", ""},
+		{"json object wildcard", jsonDocument, "config.nested.*", []string{"1.5"}, ""},
+		{"json missing key", jsonDocument, "missing", nil, "no match found"},
+		{"json out of range", jsonDocument, "releases[5]", nil, "no match found"},
+		{"json object", jsonDocument, "config", nil, "query selects a map[string]interface {}, not a scalar value"},
+		{"invalid query", jsonDocument, "releases[x]", nil, `invalid query "releases[x]": invalid index "x"`},
+		{"yaml key", yamlDocument, "name", "example", ""},
+		{"yaml wildcard", yamlDocument, "releases.*.version", []string{"1.0.0", "1.1.0"}, ""},
+		{"yaml array", yamlDocument, "tags", []string{"a", "b"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := queryDocument(tt.document, tt.query)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.value())
+		})
+	}
+}
+
+func TestQueryURLContent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testQueryJSON))
+	}))
+	defer server.Close()
+
+	got, err := queryURLContent(t.Context(), server.URL, "releases[0].version", decodeJSON)
+	require.NoError(t, err)
+	assert.Equal(t, queryResult{Values: []string{"1.0.0"}}, got)
+}
+
+func TestMatchURLHeaders(t *testing.T) {
+	t.Setenv("TEST_TOKEN", "secret")
+
+	config := map[string]map[string]string{
+		"https://api.example.com/":        {"Authorization": "Bearer ${TEST_TOKEN}", "Accept": "application/json"},
+		"https://api.example.com/private": {"authorization": "Bearer other"},
+		"https://other.example.com/":      {"X-Other": "true"},
+	}
+
+	assert.Equal(t, []header{
+		{name: "accept", value: "application/json"},
+		{name: "authorization", value: "Bearer secret"},
+	}, matchURLHeaders(config, "https://api.example.com/public/thing"))
+
+	assert.Equal(t, []header{
+		{name: "accept", value: "application/json"},
+		{name: "authorization", value: "Bearer other"},
+	}, matchURLHeaders(config, "https://api.example.com/private/thing"))
+
+	assert.Empty(t, matchURLHeaders(config, "https://example.com/"))
+}
+
+func TestURLQueryCacheKey(t *testing.T) {
+	url := "https://api.example.com/releases"
+
+	assert.Equal(t, "https://api.example.com/releases|$.tag", urlQueryCacheKey(url, "$.tag", nil))
+
+	authorised := urlQueryCacheKey(url, "$.tag", []header{{name: "authorization", value: "Bearer one"}})
+	assert.NotEqual(t, urlQueryCacheKey(url, "$.tag", nil), authorised)
+	assert.NotEqual(t, urlQueryCacheKey(url, "$.tag", []header{{name: "authorization", value: "Bearer two"}}), authorised)
+	assert.Equal(t, urlQueryCacheKey(url, "$.tag", []header{{name: "authorization", value: "Bearer one"}}), authorised)
+}
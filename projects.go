@@ -5,17 +5,74 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
+
+	"github.com/csmith/contempt/internal"
 )
 
 // FindProjects returns a slice of all images that can be built from this repo, sorted such that images are positioned
 // after all of their dependencies. It also returns a map of project names to the template file they use.
 func FindProjects(dir string, templateNames ...string) ([]string, map[string]string, error) {
-	depList := make(map[string][]string)
+	levels, projectTemplates, err := FindProjectLevels(dir, templateNames...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var res []string
+	for i := range levels {
+		res = append(res, levels[i]...)
+	}
+	return res, projectTemplates, nil
+}
+
+// FindProjectLevels returns all images that can be built from this repo, grouped into levels. Each level contains only
+// images whose dependencies are all in earlier levels, so images within the same level may be built concurrently.
+// Images within each level are sorted alphabetically. It also returns a map of project names to the template file
+// they use.
+func FindProjectLevels(dir string, templateNames ...string) ([][]string, map[string]string, error) {
+	projects, err := FindProjectGraph(dir, templateNames...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var res [][]string
 	projectTemplates := make(map[string]string)
-	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+	for _, p := range projects {
+		if p.Level == len(res) {
+			res = append(res, nil)
+		}
+		res[p.Level] = append(res[p.Level], p.Name)
+		projectTemplates[p.Name] = path.Base(p.Template)
+	}
+	return res, projectTemplates, nil
+}
+
+// Project is a single image that can be built from this repo, and its place in the dependency graph.
+type Project struct {
+	// Name is the name of the project, which is the name of the directory containing its template.
+	Name string `json:"name"`
+	// Template is the path of the project's template, relative to the repo.
+	Template string `json:"template"`
+	// Dependencies are the projects that this project's image is built from.
+	Dependencies []string `json:"dependencies"`
+	// Dependents are the projects whose images are built from this project's image.
+	Dependents []string `json:"dependents"`
+	// Config is the project's build configuration, read from its contempt.yaml file.
+	Config ProjectConfig `json:"config"`
+	// Level is the position of the project in the build order. Projects in level 0 have no dependencies, and every
+	// other project is one level higher than its highest dependency.
+	Level int `json:"level"`
+}
+
+// FindProjectGraph returns all images that can be built from this repo, along with their dependencies and
+// dependents. Projects are sorted by level, and then alphabetically.
+func FindProjectGraph(dir string, templateNames ...string) ([]*Project, error) {
+	projects := make(map[string]*Project)
+	projectDirs := make(map[string]string)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -26,68 +83,181 @@ func FindProjects(dir string, templateNames ...string) ([]string, map[string]str
 
 		for _, tn := range templateNames {
 			if d.Name() == tn {
-				project := filepath.Dir(path)
+				project := filepath.Dir(p)
 				if _, err := os.Stat(filepath.Join(project, "IGNORE")); errors.Is(err, os.ErrNotExist) {
 					name := filepath.Base(project)
-					projectTemplates[name] = tn
-					depList[name] = dependencies(project, tn)
+					template, err := filepath.Rel(dir, p)
+					if err != nil {
+						return err
+					}
+
+					config, err := LoadProjectConfig(project)
+					if err != nil {
+						return err
+					}
+
+					projects[name] = &Project{
+						Name:       name,
+						Template:   filepath.ToSlash(template),
+						Dependents: []string{},
+						Config:     config,
+					}
+					projectDirs[name] = project
 				}
 			}
 		}
 		return nil
 	})
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	var res []string
-	satisfied := func(reqs []string) bool {
-		found := 0
-		for i := range reqs {
-			for j := range res {
-				if res[j] == reqs[i] {
-					found++
-					break
-				}
-			}
-		}
-		return found == len(reqs)
+	configs := make(map[string]ProjectConfig)
+	for name, p := range projects {
+		configs[name] = p.Config
 	}
 
-	for len(depList) > 0 {
-		var batch []string
-		for d := range depList {
-			if satisfied(depList[d]) {
-				batch = append(batch, d)
-				delete(depList, d)
-			}
+	index := NewImageIndex(imageRegistry, configs)
+	depList := make(map[string][]string)
+	for name, p := range projects {
+		images, err := TemplateImages(projectDirs[name], path.Base(p.Template))
+		if err != nil {
+			return nil, fmt.Errorf("unable to find dependencies of %s: %w", name, err)
 		}
-		if len(batch) == 0 {
-			return nil, nil, fmt.Errorf("could not fully resolve dependencies: %#v", depList)
+
+		p.Dependencies = index.Dependencies(name, images)
+		depList[name] = p.Dependencies
+	}
+
+	levels, err := ResolveLevels(depList)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*Project
+	for level := range levels {
+		for _, name := range levels[level] {
+			projects[name].Level = level
+			res = append(res, projects[name])
 		}
+	}
 
-		sort.Strings(batch)
-		res = append(res, batch...)
+	for _, p := range res {
+		for _, dep := range p.Dependencies {
+			projects[dep].Dependents = append(projects[dep].Dependents, p.Name)
+		}
+	}
+	for _, p := range res {
+		sort.Strings(p.Dependents)
 	}
 
-	return res, projectTemplates, nil
+	return res, nil
+}
+
+// OutputName returns the name of the file that should be generated from the given template. Containerfile templates
+// always produce a Containerfile; all other templates use the given default name.
+func OutputName(templateName, defaultName string) string {
+	if templateName == "Containerfile.gotpl" {
+		return "Containerfile"
+	}
+	return defaultName
 }
 
-func dependencies(dir, templateName string) []string {
-	templatePath := filepath.Join(dir, templateName)
+// imageFunctions are the template functions whose first argument is a reference to an image the template depends on.
+var imageFunctions = []string{"image", "image_for", "image_tag"}
 
-	calls, err := engine.DryRun(templatePath)
+// TemplateImages returns the references of all images that the template in the given project directory uses, by
+// dry-running the template and examining the images passed to the image functions. InitTemplates must be called
+// first.
+func TemplateImages(dir, templateName string) ([]string, error) {
+	calls, err := engine.DryRun(filepath.Join(dir, templateName))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	var res []string
-	for i := range calls["image"] {
-		dep := calls["image"][i][0].(string)
-		if index := strings.IndexByte(dep, '.'); index == -1 || index > strings.IndexByte(dep, '/') {
-			res = append(res, dep)
+	for _, f := range imageFunctions {
+		for i := range calls[f] {
+			if len(calls[f][i]) == 0 {
+				continue
+			}
+
+			if ref, ok := calls[f][i][0].(string); ok {
+				res = append(res, ref)
+			}
 		}
 	}
+	return res, nil
+}
 
+// HasRegistry determines whether the image reference starts with a registry host, e.g. "ghcr.io/csmith/contempt" or
+// "localhost:5000/alpine", rather than being relative to the registry set by -registry. Template functions use the
+// same check when looking up images.
+func HasRegistry(ref string) bool {
+	return internal.HasRegistry(ref)
+}
+
+// ImageIndex maps the images built from this repo to the projects that build them.
+type ImageIndex struct {
+	registry string
+	projects map[string]string
+}
+
+// NewImageIndex creates an index of the images built by the given projects, keyed by project name. Each project
+// builds the images named in its config, or "<registry>/<project>" if none are configured.
+func NewImageIndex(registry string, configs map[string]ProjectConfig) ImageIndex {
+	index := ImageIndex{registry: registry, projects: make(map[string]string)}
+	for name, config := range configs {
+		images := config.Images
+		if len(images) == 0 {
+			images = []string{fmt.Sprintf("%s/%s", registry, name)}
+		}
+
+		for _, image := range images {
+			index.projects[image] = name
+		}
+	}
+	return index
+}
+
+// Project returns the name of the project that builds the given image reference, ignoring any tag or digest. Images
+// with a registry are only considered to be projects if they're built by one of the indexed projects. Images without
+// a registry are looked up as given and then under the registry, and are otherwise assumed to be projects in this
+// repo, even if no project with that name exists.
+func (i ImageIndex) Project(ref string) (string, bool) {
+	name, _, _ := strings.Cut(ref, "@")
+	if n := strings.LastIndexByte(name, ':'); n > strings.LastIndexByte(name, '/') {
+		name = name[:n]
+	}
+
+	if HasRegistry(name) {
+		project, ok := i.projects[name]
+		return project, ok
+	}
+
+	if project, ok := i.projects[name]; ok {
+		return project, true
+	}
+	if project, ok := i.projects[fmt.Sprintf("%s/%s", i.registry, name)]; ok {
+		return project, true
+	}
+	return name, name != ""
+}
+
+// Dependencies returns the projects that build any of the given image references, other than the given project
+// itself, sorted alphabetically.
+func (i ImageIndex) Dependencies(project string, refs []string) []string {
+	found := make(map[string]bool)
+	for _, ref := range refs {
+		if name, ok := i.Project(ref); ok && name != project {
+			found[name] = true
+		}
+	}
+
+	res := make([]string, 0, len(found))
+	for name := range found {
+		res = append(res, name)
+	}
+	sort.Strings(res)
 	return res
 }
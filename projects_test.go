@@ -0,0 +1,88 @@
+package contempt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeProjects creates a project directory for each of the given templates, keyed by project name.
+func writeProjects(t *testing.T, templates map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range templates {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, name), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name, "Dockerfile.gotpl"), []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestFindProjectGraph(t *testing.T) {
+	InitTemplates("reg.example.com", "https://dl-cdn.alpinelinux.org/alpine/", os.DirFS(t.TempDir()))
+
+	dir := writeProjects(t, map[string]string{
+		"base":  `FROM {{image "docker.io/library/alpine"}}`,
+		"go":    `FROM {{image "base:latest"}}`,
+		"tool":  "FROM {{image \"go\"}} AS build\nFROM {{image_for \"reg.example.com/base\" \"linux/arm64\"}}",
+		"app":   "FROM {{image \"go\"}} AS build\nFROM {{image_tag \"tool\" \"^1\"}}",
+		"other": "FROM {{image \"ghcr.io/example/other\"}}\nCOPY --from={{image \"reg.example.com/external\"}} / /",
+		"cli":   "FROM {{image \"base\"}}\nCOPY --from={{image \"ghcr.io/example/other\"}} / /",
+	})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other", ProjectConfigName), []byte("images:\n  - ghcr.io/example/other\n"), 0644))
+
+	projects, err := FindProjectGraph(dir, "Dockerfile.gotpl")
+	require.NoError(t, err)
+
+	assert.Equal(t, []*Project{
+		{Name: "base", Template: "base/Dockerfile.gotpl", Dependencies: []string{}, Dependents: []string{"cli", "go", "tool"}, Level: 0},
+		{Name: "other", Template: "other/Dockerfile.gotpl", Dependencies: []string{}, Dependents: []string{"cli"}, Config: ProjectConfig{Images: []string{"ghcr.io/example/other"}}, Level: 0},
+		{Name: "cli", Template: "cli/Dockerfile.gotpl", Dependencies: []string{"base", "other"}, Dependents: []string{}, Level: 1},
+		{Name: "go", Template: "go/Dockerfile.gotpl", Dependencies: []string{"base"}, Dependents: []string{"app", "tool"}, Level: 1},
+		{Name: "tool", Template: "tool/Dockerfile.gotpl", Dependencies: []string{"base", "go"}, Dependents: []string{"app"}, Level: 2},
+		{Name: "app", Template: "app/Dockerfile.gotpl", Dependencies: []string{"go", "tool"}, Dependents: []string{}, Level: 3},
+	}, projects)
+
+	levels, templates, err := FindProjectLevels(dir, "Dockerfile.gotpl")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"base", "other"}, {"cli", "go"}, {"tool"}, {"app"}}, levels)
+	assert.Equal(t, "Dockerfile.gotpl", templates["app"])
+}
+
+func TestImageIndex(t *testing.T) {
+	index := NewImageIndex("reg.example.com", map[string]ProjectConfig{
+		"base": {},
+		"go":   {},
+		"app":  {},
+		"tool": {Images: []string{"ghcr.io/example/tool", "example/tool"}},
+	})
+
+	tests := []struct {
+		ref         string
+		wantProject string
+		wantOk      bool
+	}{
+		{"base", "base", true},
+		{"base:latest", "base", true},
+		{"go@sha256:abcd", "go", true},
+		{"missing", "missing", true},
+		{"reg.example.com/app:1.2", "app", true},
+		{"reg.example.com/external", "", false},
+		{"ghcr.io/example/base", "", false},
+		{"localhost:5000/base", "", false},
+		{"ghcr.io/example/tool:1", "tool", true},
+		{"example/tool", "tool", true},
+		{"reg.example.com/tool", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			project, ok := index.Project(tt.ref)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.wantProject, project)
+		})
+	}
+
+	assert.Equal(t, []string{"base", "go"}, index.Dependencies("app", []string{"go", "reg.example.com/base:1", "app", "docker.io/library/alpine"}))
+}
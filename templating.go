@@ -2,6 +2,7 @@ package contempt
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/csmith/contempt/pkg/materials"
@@ -13,38 +14,78 @@ import (
 	"path/filepath"
 )
 
-var engine *template.Engine
+var (
+	engine        *template.Engine
+	imageRegistry string
+)
 
-func InitTemplates(imageRegistry, alpineMirror string, includes fs.FS) {
+func InitTemplates(registry, alpineMirror string, includes fs.FS, opts ...template.Option) {
+	imageRegistry = registry
 	engine = template.NewEngine(
 		slog.New(slog.NewTextHandler(os.Stdout, nil)),
 		includes,
+		opts...,
 	)
 
 	engine.Register(sources.AlpinePackagesSource(alpineMirror))
-	engine.Register(sources.ImageSource(imageRegistry))
+	engine.Register(sources.DebianPackagesSource())
+	engine.Register(sources.UbuntuPackagesSource())
+	engine.Register(sources.RpmPackagesSource())
+	engine.Register(sources.ImageSource(registry))
 	engine.Register(sources.GitSource())
+	engine.Register(sources.ReleaseAssetSource())
 	engine.Register(sources.HttpSource())
 	engine.Register(sources.AlpineReleaseSource(alpineMirror))
 	engine.Register(sources.GoReleaseSource())
 	engine.Register(sources.PostgresReleaseSource())
-	engine.Register(sources.UtilSource())
+	engine.Register(sources.PyPISource())
+	engine.Register(sources.NpmSource())
+	engine.Register(sources.CrateSource())
+	engine.Register(sources.GoModSource())
+	engine.RegisterLocal(sources.UtilSource())
 }
 
-func Generate(sourceLink, inBase, inRelativePath, outFile string) ([]materials.Change, error) {
-	oldMaterials := materials.Read(outFile)
+// Render executes the template at inRelativePath (relative to inBase), and returns the generated content, including
+// the header that records where it was generated from and its bill of materials.
+func Render(ctx context.Context, sourceLink, inBase, inRelativePath string) ([]byte, materials.BOM, error) {
 	inFile := filepath.Join(inBase, inRelativePath)
 
 	writer := &bytes.Buffer{}
-	newMaterials, err := engine.Execute(writer, inFile)
+	newMaterials, err := engine.Execute(ctx, writer, inFile)
 	if err != nil {
-		return nil, fmt.Errorf("unable to render template file %s: %v", outFile, err)
+		return nil, nil, err
 	}
 
 	bom, _ := json.Marshal(newMaterials)
 	header := fmt.Sprintf("# Generated from %s%s\n# BOM: %s\n\n", sourceLink, inRelativePath, bom)
 
-	content := append([]byte(header), writer.Bytes()...)
+	return append([]byte(header), writer.Bytes()...), newMaterials, nil
+}
+
+// Check renders the template at inRelativePath (relative to inBase) in memory, and returns the changes between the
+// bill of materials of the existing outFile and the newly rendered version. It also returns whether the content of
+// outFile differs at all from the rendered version, which may be the case even if no materials have changed (e.g. if
+// the template itself has been edited, or outFile does not exist). Nothing is written to disk.
+func Check(ctx context.Context, sourceLink, inBase, inRelativePath, outFile string) ([]materials.Change, bool, error) {
+	oldMaterials := materials.Read(outFile)
+
+	content, newMaterials, err := Render(ctx, sourceLink, inBase, inRelativePath)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to render template file %s: %v", outFile, err)
+	}
+
+	existing, err := os.ReadFile(outFile)
+	return materials.Diff(oldMaterials, newMaterials), err != nil || !bytes.Equal(existing, content), nil
+}
+
+func Generate(ctx context.Context, sourceLink, inBase, inRelativePath, outFile string) ([]materials.Change, error) {
+	oldMaterials := materials.Read(outFile)
+
+	content, newMaterials, err := Render(ctx, sourceLink, inBase, inRelativePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to render template file %s: %v", outFile, err)
+	}
+
 	if err := os.WriteFile(outFile, content, os.FileMode(0600)); err != nil {
 		return nil, fmt.Errorf("unable to write container file to %s: %v", outFile, err)
 	}